@@ -1,3 +1,41 @@
 package caddytftp
 
-import _ "github.com/lion7/caddytftp/internal"
+import (
+	"io/fs"
+
+	"github.com/lion7/caddytftp/internal"
+)
+
+// Types needed to implement modules in the tftp.handlers namespace.
+type (
+	Request           = internal.Request
+	Handler           = internal.Handler
+	HandlerFunc       = internal.HandlerFunc
+	MiddlewareHandler = internal.MiddlewareHandler
+)
+
+// Types needed to implement modules in the tftp.matchers namespace.
+type (
+	RequestMatcher = internal.RequestMatcher
+	MatcherSet     = internal.MatcherSet
+	MatcherSets    = internal.MatcherSets
+)
+
+// UploadHook is the type needed to implement modules in the tftp.hooks namespace.
+type UploadHook = internal.UploadHook
+
+// Authorizer is the type needed to implement modules in the tftp.authorizers namespace.
+type Authorizer = internal.Authorizer
+
+// Request methods.
+const (
+	MethodRead  = internal.MethodRead
+	MethodWrite = internal.MethodWrite
+)
+
+// RegisterEmbeddedFS makes fsys, e.g. an embed.FS compiled into a custom
+// build, available to the caddy.fs.tftp_embedded file system as name.
+// Call it from an init function.
+func RegisterEmbeddedFS(name string, fsys fs.FS) {
+	internal.RegisterEmbeddedFS(name, fsys)
+}
@@ -0,0 +1,215 @@
+package internal
+
+import (
+	"encoding/json"
+	"maps"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/pin/tftp/v3"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+// AccessLogs configures the access logs of a server.
+// In JSON, true enables them with the defaults.
+type AccessLogs struct {
+	// The name of the logger to write access logs to, e.g.
+	// http.log.access.tftp to route them like the access logs
+	// of Caddy's HTTP servers. Default is tftp.<server>.access.
+	LoggerName string `json:"logger_name,omitempty"`
+
+	// The fields to log; default is all fields. The fields are
+	// transfer_id, remote_ip, remote_port, method, uri, bytes_read, bytes_written,
+	// duration, options, requested_options, retransmits, traceID, spanID,
+	// error, error_code and the static fields.
+	Include []string `json:"include,omitempty"`
+
+	// The fields not to log.
+	Exclude []string `json:"exclude,omitempty"`
+
+	// Logs the options the client requested, the ones negotiated with it,
+	// e.g. blksize and tsize, and the number of retransmitted datagrams.
+	Options bool `json:"options,omitempty"`
+
+	// Static fields to add to every entry, e.g. a user_id.
+	// Placeholders are supported, e.g. {tftp.client.ip}.
+	Fields map[string]string `json:"fields,omitempty"`
+
+	// Logs only 1 in every sample successful transfers, to keep the
+	// volume down during boot storms; failed transfers are always
+	// logged. Default is 1, which logs every transfer.
+	Sample int64 `json:"sample,omitempty"`
+
+	disabled bool
+}
+
+// UnmarshalJSON accepts a boolean as well as an object.
+func (al *AccessLogs) UnmarshalJSON(b []byte) error {
+	var enabled bool
+	if err := json.Unmarshal(b, &enabled); err == nil {
+		*al = AccessLogs{disabled: !enabled}
+		return nil
+	}
+	type accessLogs AccessLogs
+	return json.Unmarshal(b, (*accessLogs)(al))
+}
+
+// accessLogger writes the access log entries of a server.
+type accessLogger struct {
+	*zap.Logger
+	include []string
+	exclude []string
+	options bool
+	fields  map[string]string
+	sample  int64
+	count   atomic.Int64
+}
+
+// newAccessLogger creates the access logger of the server named name,
+// or returns nil if access logs are disabled.
+func newAccessLogger(ctx caddy.Context, name string, al *AccessLogs) *accessLogger {
+	if al == nil || al.disabled {
+		return nil
+	}
+	log := ctx.Logger().Named(name).Named("access")
+	if al.LoggerName != "" {
+		// the logging config picks the logs of a module by its ID, so
+		// the first element of the name must pose as the module
+		namespace, rest, _ := strings.Cut(al.LoggerName, ".")
+		log = ctx.Logger(loggerModule(namespace)).Named(rest)
+	}
+	return &accessLogger{
+		Logger:  log,
+		include: al.Include,
+		exclude: al.Exclude,
+		options: al.Options,
+		fields:  al.Fields,
+		sample:  al.Sample,
+	}
+}
+
+// log writes the entry of r, which was handled in d and failed with err, if not nil.
+func (l *accessLogger) log(r *Request, filename string, d time.Duration, err error) {
+	if err == nil && l.sample > 1 && l.count.Add(1)%l.sample != 1 {
+		return
+	}
+	fields := []zap.Field{
+		zap.String("transfer_id", r.ID),
+		zap.String("remote_ip", r.RemoteAddr.IP.String()),
+		zap.Int("remote_port", r.RemoteAddr.Port),
+	}
+	if r.Method == MethodRead {
+		fields = append(fields,
+			zap.String("method", "GET"),
+			zap.String("uri", filename),
+			zap.Int64("bytes_written", r.Bytes()),
+		)
+	} else {
+		fields = append(fields,
+			zap.String("method", "PUT"),
+			zap.String("uri", filename),
+			zap.Int64("bytes_read", r.Bytes()),
+		)
+	}
+	fields = append(fields, zap.String("duration", d.String()))
+	if l.options {
+		if len(r.options) > 0 {
+			fields = append(fields, zap.Any("options", r.options))
+		}
+		if len(r.requested) > 0 {
+			fields = append(fields, zap.Any("requested_options", r.requested))
+		}
+		fields = append(fields, zap.Int("retransmits", r.retransmits))
+	}
+	if sc := trace.SpanContextFromContext(r.ctx); sc.IsValid() {
+		fields = append(fields,
+			zap.String("traceID", sc.TraceID().String()),
+			zap.String("spanID", sc.SpanID().String()),
+		)
+	}
+	if err != nil {
+		fields = append(fields,
+			zap.String("error", err.Error()),
+			zap.Int("error_code", r.errorCode),
+		)
+	}
+	repl := r.Replacer()
+	for _, k := range slices.Sorted(maps.Keys(l.fields)) {
+		fields = append(fields, zap.String(k, repl.ReplaceAll(l.fields[k], "")))
+	}
+	fields = slices.DeleteFunc(fields, func(f zap.Field) bool {
+		return (len(l.include) > 0 && !slices.Contains(l.include, f.Key)) ||
+			slices.Contains(l.exclude, f.Key)
+	})
+
+	level := zapcore.InfoLevel
+	if err != nil {
+		level = zapcore.ErrorLevel
+	}
+	l.Log(level, "handled request", fields...)
+}
+
+// transferHook records the options negotiated for the transfers of s,
+// and how many datagrams had to be retransmitted.
+type transferHook struct {
+	s *tftpServer
+}
+
+func (h transferHook) OnSuccess(stats tftp.TransferStats) {
+	h.record(stats)
+}
+
+func (h transferHook) OnFailure(stats tftp.TransferStats, _ error) {
+	h.record(stats)
+}
+
+// record stores stats with the request they belong to.
+// The hook is called from within the transfer, while it is in-flight.
+func (h transferHook) record(stats tftp.TransferStats) {
+	t := h.s.transfers.find(stats.RemoteAddr, stats.Tid)
+	if t == nil {
+		return
+	}
+	recordStats(t.request, stats)
+}
+
+// recordUpload stores the stats of the upload r, which started at start.
+// The library calls the hook for successful uploads only after the write
+// handler returned, too late for the logs, but by the time the handlers
+// are done the options are negotiated and the last block acknowledged.
+func (h transferHook) recordUpload(r *Request, start time.Time) {
+	if r.statsRecorded {
+		// the hook already fired for an upload the library aborted
+		return
+	}
+	sent, acked := transferDatagrams(r.wt)
+	recordStats(r, tftp.TransferStats{
+		Opts:           transferOptions(r.wt),
+		Duration:       time.Since(start),
+		DatagramsSent:  sent,
+		DatagramsAcked: acked,
+	})
+}
+
+// recordStats stores stats with r.
+func recordStats(r *Request, stats tftp.TransferStats) {
+	r.statsRecorded = true
+	r.options = maps.Clone(map[string]string(stats.Opts))
+	r.retransmits = max(stats.DatagramsSent-stats.DatagramsAcked, 0)
+	if r.packetLog != nil {
+		r.logStats(stats)
+	}
+}
+
+// loggerModule creates loggers in an arbitrary namespace, which the
+// logging config routes like the logs of the module with that ID.
+type loggerModule string
+
+func (m loggerModule) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{ID: caddy.ModuleID(m)}
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path"
+	"slices"
+	"strings"
+)
+
+// AccessRule restricts the clients that may access matching files.
+// A request must satisfy every rule that applies to it.
+type AccessRule struct {
+	// Glob patterns of the file names the rule applies to, matched like
+	// the hide patterns of the server. Default is all files.
+	Files []string `json:"files,omitempty"`
+
+	// The methods the rule applies to: read and/or write.
+	// Default is both.
+	Methods []string `json:"methods,omitempty"`
+
+	// IP addresses or CIDR ranges of clients that may access the files.
+	// Default is all clients.
+	Allow []string `json:"allow,omitempty"`
+
+	// IP addresses or CIDR ranges of clients that may not access the files.
+	// Deny takes precedence over allow.
+	Deny []string `json:"deny,omitempty"`
+}
+
+// accessRule is a provisioned AccessRule.
+type accessRule struct {
+	files   []string
+	methods []string
+	allow   []netip.Prefix
+	deny    []netip.Prefix
+}
+
+// provision validates the rule and parses its address lists.
+func (ar AccessRule) provision() (accessRule, error) {
+	for _, pattern := range ar.Files {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return accessRule{}, fmt.Errorf("invalid files pattern %s: %v", pattern, err)
+		}
+	}
+	var methods []string
+	for _, method := range ar.Methods {
+		switch method {
+		case "read":
+			methods = append(methods, MethodRead)
+		case "write":
+			methods = append(methods, MethodWrite)
+		default:
+			return accessRule{}, fmt.Errorf("unrecognized method '%s'", method)
+		}
+	}
+	allow, err := parsePrefixes(ar.Allow)
+	if err != nil {
+		return accessRule{}, fmt.Errorf("allow: %v", err)
+	}
+	deny, err := parsePrefixes(ar.Deny)
+	if err != nil {
+		return accessRule{}, fmt.Errorf("deny: %v", err)
+	}
+	return accessRule{files: ar.Files, methods: methods, allow: allow, deny: deny}, nil
+}
+
+// applies reports whether the rule applies to r.
+func (ar accessRule) applies(r *Request) bool {
+	if len(ar.methods) > 0 && !slices.Contains(ar.methods, r.Method) {
+		return false
+	}
+	return len(ar.files) == 0 || matchPathFold(ar.files, r.Filename, r.foldCase)
+}
+
+// permitted reports whether r satisfies all access rules that apply to it.
+func (s *tftpServer) permitted(r *Request) bool {
+	for _, rule := range s.rules {
+		if rule.applies(r) && !allowedBy(r.RemoteAddr.IP, rule.allow, rule.deny) {
+			return false
+		}
+	}
+	return true
+}
+
+// allowedBy reports whether ip is in allow, or allow is empty, and not in deny.
+func allowedBy(ip net.IP, allow, deny []netip.Prefix) bool {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range deny {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+	if len(allow) == 0 {
+		return true
+	}
+	for _, prefix := range allow {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathFold is matchPath, ignoring case if fold is set. Servers that
+// resolve file names case-insensitively match the patterns that restrict
+// access this way, so e.g. SECURE/x cannot bypass a rule for secure/*.
+func matchPathFold(patterns []string, filename string, fold bool) bool {
+	if !fold {
+		return matchPath(patterns, filename)
+	}
+	folded := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		folded[i] = strings.ToLower(pattern)
+	}
+	return matchPath(folded, strings.ToLower(filename))
+}
+
+// matchPath reports whether filename matches one of the glob patterns.
+// A pattern without a slash matches any element of the path, a pattern
+// with a slash matches the path from the root or any of its parents.
+func matchPath(patterns []string, filename string) bool {
+	filename = strings.TrimPrefix(path.Clean("/"+filename), "/")
+	elems := strings.Split(filename, "/")
+	for _, pattern := range patterns {
+		if !strings.Contains(pattern, "/") {
+			for _, elem := range elems {
+				if ok, _ := path.Match(pattern, elem); ok {
+					return true
+				}
+			}
+			continue
+		}
+		for i := range elems {
+			if ok, _ := path.Match(pattern, strings.Join(elems[:i+1], "/")); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package internal
+
+import (
+	"net"
+	"testing"
+)
+
+func TestCaseInsensitiveChecks(t *testing.T) {
+	rule, err := AccessRule{Files: []string{"secure/*"}, Allow: []string{"10.0.0.0/8"}}.provision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, foldCase := range []bool{false, true} {
+		s := &tftpServer{hide: []string{"*.key"}, rules: []accessRule{rule}, foldCase: foldCase}
+		for _, tc := range []struct {
+			filename      string
+			hidden        bool
+			permitted     bool
+			caseDependent bool
+		}{
+			{filename: "foo.key", hidden: true, permitted: true},
+			{filename: "foo.KEY", hidden: true, permitted: true, caseDependent: true},
+			{filename: "secure/x", permitted: false},
+			{filename: "SECURE/x", permitted: false, caseDependent: true},
+			{filename: "Secure/X.Key", hidden: true, permitted: false, caseDependent: true},
+			{filename: "public/x", permitted: true},
+		} {
+			wantHidden, wantPermitted := tc.hidden, tc.permitted
+			if tc.caseDependent && !foldCase {
+				wantHidden, wantPermitted = false, true
+			}
+			r := &Request{Method: MethodRead, Filename: tc.filename, foldCase: foldCase}
+			r.RemoteAddr.IP = net.ParseIP("192.0.2.1")
+			if got := s.hidden(tc.filename); got != wantHidden {
+				t.Errorf("foldCase=%v: hidden(%q) = %v, want %v", foldCase, tc.filename, got, wantHidden)
+			}
+			if got := s.permitted(r); got != wantPermitted {
+				t.Errorf("foldCase=%v: permitted(%q) = %v, want %v", foldCase, tc.filename, got, wantPermitted)
+			}
+		}
+	}
+}
+
+func TestMatchPath(t *testing.T) {
+	for _, tc := range []struct {
+		patterns []string
+		filename string
+		want     bool
+	}{
+		{[]string{"*.key"}, "foo.key", true},
+		{[]string{"*.key"}, "certs/foo.key", true},
+		{[]string{"*.key"}, "foo.key.bak", false},
+		{[]string{".git"}, "repo/.git/config", true},
+		{[]string{"secrets/*"}, "secrets/a", true},
+		{[]string{"secrets/*"}, "secrets/a/b", true},
+		{[]string{"secrets/*"}, "other/secrets/a", false},
+		{[]string{"secrets/*"}, "/secrets/../secrets/a", true},
+		{[]string{"secrets/*"}, "../secrets/a", true},
+		{[]string{"secrets"}, "secrets", true},
+		{[]string{"pxelinux.cfg/*"}, "pxelinux.cfg", false},
+		{[]string{"*.iso", "*.img"}, "boot/disk.img", true},
+		{[]string{"*.KEY"}, "foo.key", false},
+		{nil, "foo", false},
+	} {
+		if got := matchPath(tc.patterns, tc.filename); got != tc.want {
+			t.Errorf("matchPath(%q, %q) = %v, want %v", tc.patterns, tc.filename, got, tc.want)
+		}
+	}
+}
+
+func TestAccessRules(t *testing.T) {
+	rules := make([]accessRule, 0, 3)
+	for _, ar := range []AccessRule{
+		{Files: []string{"admin/*"}, Allow: []string{"10.0.0.0/8"}},
+		{Files: []string{"*.cfg"}, Methods: []string{"write"}, Allow: []string{"192.0.2.10"}},
+		{Deny: []string{"198.51.100.0/24"}},
+	} {
+		rule, err := ar.provision()
+		if err != nil {
+			t.Fatal(err)
+		}
+		rules = append(rules, rule)
+	}
+	s := &tftpServer{rules: rules}
+	for _, tc := range []struct {
+		method   string
+		ip       string
+		filename string
+		want     bool
+	}{
+		{MethodRead, "10.1.2.3", "admin/x", true},
+		{MethodRead, "192.0.2.1", "admin/x", false},
+		{MethodRead, "192.0.2.1", "admin", true},
+		{MethodRead, "192.0.2.1", "public/admin/x", true},
+		{MethodRead, "192.0.2.1", "x.cfg", true},
+		{MethodWrite, "192.0.2.1", "x.cfg", false},
+		{MethodWrite, "192.0.2.10", "x.cfg", true},
+		{MethodWrite, "::ffff:192.0.2.10", "x.cfg", true},
+		{MethodRead, "198.51.100.7", "x", false},
+		{MethodRead, "198.51.100.7", "admin/x", false},
+	} {
+		r := &Request{Method: tc.method, Filename: tc.filename}
+		r.RemoteAddr.IP = net.ParseIP(tc.ip)
+		if got := s.permitted(r); got != tc.want {
+			t.Errorf("permitted(%s %s from %s) = %v, want %v", tc.method, tc.filename, tc.ip, got, tc.want)
+		}
+	}
+}
+
+func TestAccessRuleProvision(t *testing.T) {
+	for _, ar := range []AccessRule{
+		{Files: []string{"["}},
+		{Methods: []string{"delete"}},
+		{Allow: []string{"10.0.0.0/33"}},
+		{Deny: []string{"not an address"}},
+	} {
+		if _, err := ar.provision(); err == nil {
+			t.Errorf("provision(%+v) succeeded, want error", ar)
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(adminAPI{})
+}
+
+// adminAPI is a module that serves endpoints to inspect
+// and manage the transfers of the TFTP servers:
+//
+//	GET    /tftp/servers                        lists the status of every server
+//	GET    /tftp/servers/<name>                 returns the status of a server
+//	GET    /tftp/servers/<name>/transfers       lists the in-flight transfers
+//	DELETE /tftp/servers/<name>/transfers/<id>  cancels a transfer
+//	GET    /tftp/servers/<name>/stats           returns the statistics per file
+//	DELETE /tftp/servers/<name>/stats           resets the statistics per file
+//	POST   /tftp/servers/<name>/drain           refuses new requests of a server
+//	POST   /tftp/servers/<name>/resume          accepts new requests of a server again
+type adminAPI struct {
+	ctx     caddy.Context
+	log     *zap.Logger
+	tftpApp *TFTP
+}
+
+// CaddyModule returns the Caddy module information.
+func (adminAPI) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "admin.api.tftp",
+		New: func() caddy.Module { return new(adminAPI) },
+	}
+}
+
+// Provision sets up the adminAPI module.
+func (a *adminAPI) Provision(ctx caddy.Context) error {
+	a.ctx = ctx
+	a.log = ctx.Logger(a)
+
+	// The TFTP app is optional; only serve its endpoints if configured.
+	tftpApp, err := ctx.AppIfConfigured("tftp")
+	if err == nil {
+		a.tftpApp = tftpApp.(*TFTP)
+	}
+	return nil
+}
+
+// Routes returns the admin routes for the TFTP app.
+func (a *adminAPI) Routes() []caddy.AdminRoute {
+	return []caddy.AdminRoute{
+		{
+			Pattern: "/tftp/",
+			Handler: caddy.AdminHandlerFunc(a.handleAPIEndpoints),
+		},
+	}
+}
+
+// handleAPIEndpoints routes API requests within /tftp/.
+func (a *adminAPI) handleAPIEndpoints(w http.ResponseWriter, r *http.Request) error {
+	uri := strings.TrimPrefix(r.URL.Path, "/tftp/")
+	parts := strings.Split(uri, "/")
+	switch {
+	case len(parts) == 1 && parts[0] == "servers":
+		return a.handleServers(w, r)
+	case len(parts) == 2 && parts[0] == "servers" && parts[1] != "":
+		return a.handleServer(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "servers" && parts[2] == "transfers":
+		return a.handleTransfers(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "servers" && parts[2] == "stats":
+		return a.handleStats(w, r, parts[1])
+	case len(parts) == 3 && parts[0] == "servers" && (parts[2] == "drain" || parts[2] == "resume"):
+		return a.handleDrain(w, r, parts[1], parts[2] == "drain")
+	case len(parts) == 4 && parts[0] == "servers" && parts[2] == "transfers" && parts[3] != "":
+		return a.handleTransfer(w, r, parts[1], parts[3])
+	default:
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("resource not found: %v", r.URL.Path),
+		}
+	}
+}
+
+// handleServers lists the status of every server.
+func (a *adminAPI) handleServers(w http.ResponseWriter, r *http.Request) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	statuses := []serverStatus{}
+	if a.tftpApp != nil {
+		for _, s := range a.tftpApp.servers {
+			statuses = append(statuses, s.status())
+		}
+	}
+	return writeJSON(w, statuses)
+}
+
+// handleServer returns the status of a server.
+func (a *adminAPI) handleServer(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	s, err := a.server(name)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, s.status())
+}
+
+// handleTransfers lists the in-flight transfers of a server.
+func (a *adminAPI) handleTransfers(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodGet {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	s, err := a.server(name)
+	if err != nil {
+		return err
+	}
+	return writeJSON(w, s.transfers.list())
+}
+
+// handleTransfer cancels an in-flight transfer of a server.
+func (a *adminAPI) handleTransfer(w http.ResponseWriter, r *http.Request, name, id string) error {
+	if r.Method != http.MethodDelete {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	s, err := a.server(name)
+	if err != nil {
+		return err
+	}
+	if !s.transfers.cancel(id) {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("no transfer with id %s", id),
+		}
+	}
+	a.log.Info("transfer cancelled", zap.String("server", name), zap.String("transfer_id", id))
+	w.WriteHeader(http.StatusOK)
+	return nil
+}
+
+// handleStats returns or resets the statistics per file of a server.
+func (a *adminAPI) handleStats(w http.ResponseWriter, r *http.Request, name string) error {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	s, err := a.server(name)
+	if err != nil {
+		return err
+	}
+	if s.fileStats == nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("file_stats is not enabled for server %s", name),
+		}
+	}
+	if r.Method == http.MethodDelete {
+		s.fileStats.restart()
+		a.log.Info("file statistics reset", zap.String("server", name))
+		w.WriteHeader(http.StatusOK)
+		return nil
+	}
+	return writeJSON(w, s.fileStats.report())
+}
+
+// handleDrain puts a server into drain mode, in which it finishes its
+// in-flight transfers but refuses new requests, or takes it out of it.
+func (a *adminAPI) handleDrain(w http.ResponseWriter, r *http.Request, name string, drain bool) error {
+	if r.Method != http.MethodPost {
+		return caddy.APIError{
+			HTTPStatus: http.StatusMethodNotAllowed,
+			Err:        fmt.Errorf("method not allowed: %v", r.Method),
+		}
+	}
+	s, err := a.server(name)
+	if err != nil {
+		return err
+	}
+	if s.draining.Swap(drain) != drain {
+		if drain {
+			a.log.Info("server draining", zap.String("server", name), zap.Int("in_flight", s.transfers.count()))
+		} else {
+			a.log.Info("server resumed", zap.String("server", name))
+		}
+	}
+	return writeJSON(w, s.status())
+}
+
+// writeJSON writes v as the JSON response.
+func writeJSON(w http.ResponseWriter, v any) error {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		return caddy.APIError{
+			HTTPStatus: http.StatusInternalServerError,
+			Err:        fmt.Errorf("failed to encode JSON response: %v", err),
+		}
+	}
+	return nil
+}
+
+// server returns the server with the given name.
+func (a *adminAPI) server(name string) (*tftpServer, error) {
+	if a.tftpApp == nil {
+		return nil, caddy.APIError{
+			HTTPStatus: http.StatusNotFound,
+			Err:        fmt.Errorf("tftp app is not configured"),
+		}
+	}
+	for _, s := range a.tftpApp.servers {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	return nil, caddy.APIError{
+		HTTPStatus: http.StatusNotFound,
+		Err:        fmt.Errorf("no server named %s", name),
+	}
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner = (*adminAPI)(nil)
+	_ caddy.AdminRouter = (*adminAPI)(nil)
+)
@@ -0,0 +1,266 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// blockingReader blocks until its context is done.
+type blockingReader struct {
+	ctx context.Context
+}
+
+func (b blockingReader) Read([]byte) (int, error) {
+	<-b.ctx.Done()
+	return 0, b.ctx.Err()
+}
+
+// adminRequest sends a request to the admin endpoints of a and decodes
+// the JSON response into v, if given. It returns the HTTP status.
+func adminRequest(t *testing.T, a *adminAPI, method, path string, v any) int {
+	t.Helper()
+	w := httptest.NewRecorder()
+	err := a.handleAPIEndpoints(w, httptest.NewRequest(method, path, nil))
+	var apiErr caddy.APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.HTTPStatus
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v != nil {
+		if err := json.NewDecoder(w.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return w.Code
+}
+
+// eventually fails t unless cond holds within a few seconds. Servers
+// count a transfer only after the client saw its last packet.
+func eventually(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestAdminTransfers(t *testing.T) {
+	// sends the first block, then stalls until the transfer is cancelled
+	app := startApp(t, &Server{Root: t.TempDir()}, middlewareFunc(func(r *Request, next Handler) error {
+		_, err := r.ReadFrom(io.MultiReader(strings.NewReader(strings.Repeat("x", 512)), blockingReader{r.Context()}))
+		return err
+	}))
+	a := &adminAPI{tftpApp: app, log: zap.NewNop()}
+	c := newClient(t, app.servers[0].listeners[0].ln.LocalAddr().String())
+
+	errc := make(chan error, 1)
+	go func() {
+		wt, err := c.Receive("slow.img", "octet")
+		if err == nil {
+			_, err = wt.WriteTo(io.Discard)
+		}
+		errc <- err
+	}()
+
+	var list []transferInfo
+	eventually(t, func() bool {
+		if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/test/transfers", &list); code != http.StatusOK {
+			t.Fatalf("list: status %d", code)
+		}
+		return len(list) > 0
+	})
+	if len(list) != 1 || list[0].Filename != "slow.img" || list[0].Method != MethodRead {
+		t.Fatalf("listed %+v", list)
+	}
+
+	if code := adminRequest(t, a, http.MethodDelete, "/tftp/servers/test/transfers/"+list[0].ID, nil); code != http.StatusOK {
+		t.Fatalf("cancel: status %d", code)
+	}
+	select {
+	case err := <-errc:
+		if err == nil {
+			t.Error("cancelled transfer succeeded")
+		}
+	case <-time.After(time.Second):
+		t.Error("transfer not aborted")
+	}
+
+	for _, tc := range []struct {
+		method string
+		path   string
+		want   int
+	}{
+		{http.MethodDelete, "/tftp/servers/test/transfers/" + list[0].ID, http.StatusNotFound},
+		{http.MethodGet, "/tftp/servers/test/transfers/" + list[0].ID, http.StatusMethodNotAllowed},
+		{http.MethodPost, "/tftp/servers/test/transfers", http.StatusMethodNotAllowed},
+		{http.MethodGet, "/tftp/servers/other/transfers", http.StatusNotFound},
+		{http.MethodGet, "/tftp/unknown", http.StatusNotFound},
+	} {
+		if code := adminRequest(t, a, tc.method, tc.path, nil); code != tc.want {
+			t.Errorf("%s %s: status %d, want %d", tc.method, tc.path, code, tc.want)
+		}
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "boot.img"), []byte("boot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := startApp(t, &Server{Root: root, HealthFile: "__health"})
+	a := &adminAPI{tftpApp: app, log: zap.NewNop()}
+	addr := app.servers[0].listeners[0].ln.LocalAddr().String()
+
+	if got, err := download(t, addr, "__health"); err != nil || got != "OK\n" {
+		t.Errorf("__health: got %q, %v", got, err)
+	}
+	if got, err := download(t, addr, "boot.img"); err != nil || got != "boot" {
+		t.Errorf("boot.img: got %q, %v", got, err)
+	}
+	_, err := download(t, addr, "missing")
+	wantCode(t, err, errCodeFileNotFound)
+
+	var st serverStatus
+	eventually(t, func() bool {
+		if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/test", &st); code != http.StatusOK {
+			t.Fatalf("status: %d", code)
+		}
+		return st.Transfers == 2
+	})
+	// health checks are not counted as transfers
+	if st.Name != "test" || len(st.Listen) != 1 || st.Listen[0] != addr || st.Started.IsZero() {
+		t.Errorf("status: %+v", st)
+	}
+	if st.Transfers != 2 || st.Failed != 1 || st.InFlight != 0 || st.BytesSent != 4 || st.BytesReceived != 0 {
+		t.Errorf("counters: %+v", st)
+	}
+	if st.LastError == nil || st.LastError.Filename != "missing" || st.LastError.Method != MethodRead {
+		t.Errorf("last error: %+v", st.LastError)
+	}
+
+	var list []serverStatus
+	if code := adminRequest(t, a, http.MethodGet, "/tftp/servers", &list); code != http.StatusOK {
+		t.Fatalf("servers: %d", code)
+	}
+	if len(list) != 1 || list[0].Name != "test" || list[0].Transfers != 2 {
+		t.Errorf("servers: %+v", list)
+	}
+	if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/other", nil); code != http.StatusNotFound {
+		t.Errorf("other: status %d, want %d", code, http.StatusNotFound)
+	}
+
+	// without a tftp app there are no servers
+	a = &adminAPI{log: zap.NewNop()}
+	if code := adminRequest(t, a, http.MethodGet, "/tftp/servers", &list); code != http.StatusOK || len(list) != 0 {
+		t.Errorf("servers without app: status %d, %+v", code, list)
+	}
+	if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/test", nil); code != http.StatusNotFound {
+		t.Errorf("test without app: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAdminStats(t *testing.T) {
+	root := t.TempDir()
+	for _, name := range []string{"a.img", "b.img"} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(name), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	app := startApp(t, &Server{Root: root, Overwrite: "allow", FileStats: &FileStats{MaxFiles: 2}})
+	a := &adminAPI{tftpApp: app, log: zap.NewNop()}
+	addr := app.servers[0].listeners[0].ln.LocalAddr().String()
+
+	for _, name := range []string{"a.img", "b.img", "a.img"} {
+		if _, err := download(t, addr, name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// makes room by dropping b.img, the file transferred least recently
+	if err := upload(t, addr, "c.cfg", "hostname c"); err != nil {
+		t.Fatal(err)
+	}
+
+	var report fileStatsReport
+	eventually(t, func() bool {
+		if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/test/stats", &report); code != http.StatusOK {
+			t.Fatalf("stats: status %d", code)
+		}
+		return slices.ContainsFunc(report.Files, func(st fileStat) bool { return st.Filename == "c.cfg" })
+	})
+	if len(report.Files) != 2 {
+		t.Fatalf("stats: %+v", report.Files)
+	}
+	if got := report.Files[0]; got.Filename != "a.img" || got.Method != MethodRead || got.Transfers != 2 || got.Bytes != 10 || got.Failed != 0 {
+		t.Errorf("a.img: %+v", got)
+	}
+	if got := report.Files[1]; got.Filename != "c.cfg" || got.Method != MethodWrite || got.Transfers != 1 || got.Bytes != 10 {
+		t.Errorf("c.cfg: %+v", got)
+	}
+
+	if code := adminRequest(t, a, http.MethodDelete, "/tftp/servers/test/stats", nil); code != http.StatusOK {
+		t.Fatalf("reset: status %d", code)
+	}
+	if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/test/stats", &report); code != http.StatusOK || len(report.Files) != 0 {
+		t.Errorf("after reset: status %d, %+v", code, report.Files)
+	}
+	if code := adminRequest(t, a, http.MethodPost, "/tftp/servers/test/stats", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("POST: status %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+
+	// servers without file_stats have no statistics to report
+	a = &adminAPI{tftpApp: startApp(t, &Server{Root: root}), log: zap.NewNop()}
+	if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/test/stats", nil); code != http.StatusNotFound {
+		t.Errorf("without file_stats: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestAdminDrain(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "boot.img"), []byte("boot"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	app := startApp(t, &Server{Root: root, HealthFile: "__health"})
+	a := &adminAPI{tftpApp: app, log: zap.NewNop()}
+	addr := app.servers[0].listeners[0].ln.LocalAddr().String()
+
+	var st serverStatus
+	if code := adminRequest(t, a, http.MethodPost, "/tftp/servers/test/drain", &st); code != http.StatusOK || !st.Draining {
+		t.Fatalf("drain: status %d, %+v", code, st)
+	}
+	// health checks fail too, so load balancers stop sending clients
+	for _, name := range []string{"boot.img", "__health"} {
+		_, err := download(t, addr, name)
+		wantCode(t, err, errCodeNotDefined)
+		if !strings.Contains(err.Error(), errDraining.Error()) {
+			t.Errorf("%s: got %v, want %v", name, err, errDraining)
+		}
+	}
+
+	if code := adminRequest(t, a, http.MethodPost, "/tftp/servers/test/resume", &st); code != http.StatusOK || st.Draining {
+		t.Fatalf("resume: status %d, %+v", code, st)
+	}
+	if got, err := download(t, addr, "boot.img"); err != nil || got != "boot" {
+		t.Errorf("boot.img: got %q, %v", got, err)
+	}
+	if code := adminRequest(t, a, http.MethodGet, "/tftp/servers/test/drain", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET drain: status %d, want %d", code, http.StatusMethodNotAllowed)
+	}
+}
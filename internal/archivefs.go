@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(ArchiveFS{})
+	caddy.RegisterModule(EmbeddedFS{})
+}
+
+// ArchiveFS is a file system that serves the files of a zip or tar
+// archive without extracting it. Register it with Caddy's global
+// filesystems option and serve it with the fs option of file_server.
+type ArchiveFS struct {
+	// The path to the archive.
+	Path string `json:"path,omitempty"`
+
+	// The format of the archive: zip or tar.
+	// Default is derived from the file extension.
+	Format string `json:"format,omitempty"`
+
+	fs.FS `json:"-"`
+	file  *os.File
+}
+
+// CaddyModule returns the Caddy module information.
+func (ArchiveFS) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "caddy.fs.archive",
+		New: func() caddy.Module { return new(ArchiveFS) },
+	}
+}
+
+// Provision opens the archive.
+func (a *ArchiveFS) Provision(ctx caddy.Context) error {
+	if a.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	format := a.Format
+	if format == "" {
+		switch strings.ToLower(path.Ext(a.Path)) {
+		case ".zip":
+			format = "zip"
+		case ".tar":
+			format = "tar"
+		default:
+			return fmt.Errorf("cannot derive the format of %s, set it explicitly", a.Path)
+		}
+	}
+	file, err := os.Open(a.Path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	switch format {
+	case "zip":
+		a.FS, err = zip.NewReader(file, info.Size())
+	case "tar":
+		a.FS, err = newTarFS(file)
+	default:
+		err = fmt.Errorf("unsupported archive format '%s'", format)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("opening %s: %v", a.Path, err)
+	}
+	a.file = file
+	return nil
+}
+
+// Cleanup closes the archive.
+func (a *ArchiveFS) Cleanup() error {
+	if a.file == nil {
+		return nil
+	}
+	return a.file.Close()
+}
+
+// UnmarshalCaddyfile sets up the archive file system from Caddyfile tokens.
+//
+//	archive <path> {
+//	    format zip|tar
+//	}
+func (a *ArchiveFS) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume backend name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	a.Path = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "format":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			a.Format = d.Val()
+		default:
+			return d.Errf("unrecognized archive option '%s'", d.Val())
+		}
+	}
+	return nil
+}
+
+// tarFS serves the regular files of an uncompressed tar archive,
+// reading them from the archive on demand.
+type tarFS struct {
+	ra    io.ReaderAt
+	files map[string]*tarEntry
+	dirs  map[string]bool
+}
+
+type tarEntry struct {
+	hdr    *tar.Header
+	offset int64
+}
+
+// newTarFS indexes the tar archive in file.
+func newTarFS(file *os.File) (*tarFS, error) {
+	tfs := &tarFS{
+		ra:    file,
+		files: make(map[string]*tarEntry),
+		dirs:  map[string]bool{".": true},
+	}
+	tr := tar.NewReader(file)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return tfs, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		name := path.Clean(strings.TrimPrefix(hdr.Name, "/"))
+		if !fs.ValidPath(name) {
+			continue
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			tfs.addDir(name)
+		case tar.TypeReg:
+			offset, err := file.Seek(0, io.SeekCurrent)
+			if err != nil {
+				return nil, err
+			}
+			tfs.files[name] = &tarEntry{hdr: hdr, offset: offset}
+			tfs.addDir(path.Dir(name))
+		}
+	}
+}
+
+// addDir records dir and its parents as directories.
+func (tfs *tarFS) addDir(dir string) {
+	for !tfs.dirs[dir] {
+		tfs.dirs[dir] = true
+		dir = path.Dir(dir)
+	}
+}
+
+func (tfs *tarFS) Open(name string) (fs.File, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrInvalid}
+	}
+	if e, ok := tfs.files[name]; ok {
+		return &tarFile{
+			SectionReader: io.NewSectionReader(tfs.ra, e.offset, e.hdr.Size),
+			info:          e.hdr.FileInfo(),
+		}, nil
+	}
+	if tfs.dirs[name] {
+		return &tarDir{name: path.Base(name)}, nil
+	}
+	return nil, &fs.PathError{Op: "open", Path: name, Err: fs.ErrNotExist}
+}
+
+type tarFile struct {
+	*io.SectionReader
+	info fs.FileInfo
+}
+
+func (f *tarFile) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *tarFile) Close() error               { return nil }
+
+// tarDir is a directory of a tar archive, which cannot be listed.
+type tarDir struct {
+	name string
+}
+
+func (d *tarDir) Stat() (fs.FileInfo, error) { return d, nil }
+func (d *tarDir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.name, Err: fs.ErrInvalid}
+}
+func (d *tarDir) Close() error { return nil }
+
+func (d *tarDir) Name() string       { return d.name }
+func (d *tarDir) Size() int64        { return 0 }
+func (d *tarDir) Mode() fs.FileMode  { return fs.ModeDir | 0o555 }
+func (d *tarDir) ModTime() time.Time { return time.Time{} }
+func (d *tarDir) IsDir() bool        { return true }
+func (d *tarDir) Sys() any           { return nil }
+
+var (
+	embeddedMu  sync.RWMutex
+	embeddedFSs = make(map[string]fs.FS)
+)
+
+// RegisterEmbeddedFS makes fsys, e.g. an embed.FS compiled into a
+// custom build, available to the embedded file system module as name.
+func RegisterEmbeddedFS(name string, fsys fs.FS) {
+	embeddedMu.Lock()
+	defer embeddedMu.Unlock()
+	embeddedFSs[name] = fsys
+}
+
+// EmbeddedFS is a file system compiled into a custom build, which
+// registered it with caddytftp.RegisterEmbeddedFS.
+type EmbeddedFS struct {
+	// The name the file system was registered with.
+	Name string `json:"name,omitempty"`
+
+	fs.FS `json:"-"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (EmbeddedFS) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "caddy.fs.tftp_embedded",
+		New: func() caddy.Module { return new(EmbeddedFS) },
+	}
+}
+
+// Provision looks up the registered file system.
+func (e *EmbeddedFS) Provision(ctx caddy.Context) error {
+	embeddedMu.RLock()
+	defer embeddedMu.RUnlock()
+	fsys, ok := embeddedFSs[e.Name]
+	if !ok {
+		return fmt.Errorf("no embedded file system registered as '%s'", e.Name)
+	}
+	e.FS = fsys
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the embedded file system from Caddyfile tokens.
+//
+//	tftp_embedded <name>
+func (e *EmbeddedFS) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume backend name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	e.Name = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*ArchiveFS)(nil)
+	_ caddy.CleanerUpper    = (*ArchiveFS)(nil)
+	_ caddyfile.Unmarshaler = (*ArchiveFS)(nil)
+	_ fs.FS                 = (*ArchiveFS)(nil)
+	_ caddy.Provisioner     = (*EmbeddedFS)(nil)
+	_ caddyfile.Unmarshaler = (*EmbeddedFS)(nil)
+	_ fs.FS                 = (*EmbeddedFS)(nil)
+)
@@ -0,0 +1,237 @@
+package internal
+
+import (
+	"bufio"
+	"fmt"
+	"net/netip"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(FileAuthorizer{})
+}
+
+// Authorizer is a module in the tftp.authorizers namespace, which decides
+// whether a request may proceed before its transfer starts. Returning an
+// error denies the request as well, so authorization fails closed.
+type Authorizer interface {
+	Authorize(r *Request) (bool, error)
+}
+
+// FileAuthorizer authorizes requests with the rules of a text file, one
+// per line, which is read again whenever it changes:
+//
+//	# action  methods  clients                files...
+//	allow     read     10.0.0.0/8,192.0.2.7   images/* boot/*
+//	allow     write    10.99.0.0/24           backups/*
+//	deny      *        *                      *
+//
+// Methods are read, write or * for both, clients a comma-separated list
+// of IP addresses and CIDR ranges or * for all, and files glob patterns
+// matched like the hide patterns of the server. The first matching rule
+// decides; requests no rule matches get the default action.
+type FileAuthorizer struct {
+	// The path of the rule file.
+	Path string `json:"path,omitempty"`
+
+	// The action for requests that no rule matches: allow or deny.
+	// Default is deny.
+	Default string `json:"default,omitempty"`
+
+	rules *ruleFile
+}
+
+// ruleFile holds the rules of a rule file as of its last modification.
+type ruleFile struct {
+	path string
+	log  *zap.Logger
+
+	mu      sync.Mutex
+	rules   []fileRule
+	modTime time.Time
+}
+
+// fileRule is a parsed line of a rule file.
+type fileRule struct {
+	allow   bool
+	methods []string
+	clients []netip.Prefix
+	files   []string
+}
+
+// CaddyModule returns the Caddy module information.
+func (FileAuthorizer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.authorizers.file",
+		New: func() caddy.Module { return new(FileAuthorizer) },
+	}
+}
+
+// Provision reads the rule file.
+func (fa *FileAuthorizer) Provision(ctx caddy.Context) error {
+	if fa.Path == "" {
+		return fmt.Errorf("path is required")
+	}
+	if fa.Default != "" && fa.Default != "allow" && fa.Default != "deny" {
+		return fmt.Errorf("unrecognized default action '%s'", fa.Default)
+	}
+	info, err := os.Stat(fa.Path)
+	if err != nil {
+		return err
+	}
+	rules, err := readRuleFile(fa.Path)
+	if err != nil {
+		return err
+	}
+	fa.rules = &ruleFile{path: fa.Path, log: ctx.Logger(), rules: rules, modTime: info.ModTime()}
+	return nil
+}
+
+// Authorize implements Authorizer.
+func (fa *FileAuthorizer) Authorize(r *Request) (bool, error) {
+	for _, rule := range fa.rules.current() {
+		if len(rule.methods) > 0 && !slices.Contains(rule.methods, r.Method) {
+			continue
+		}
+		if len(rule.clients) > 0 && !allowedBy(r.RemoteAddr.IP, rule.clients, nil) {
+			continue
+		}
+		if !matchPathFold(rule.files, r.Filename, r.foldCase) {
+			continue
+		}
+		return rule.allow, nil
+	}
+	return fa.Default == "allow", nil
+}
+
+// current returns the rules, reading the rule file again if it changed.
+// If it cannot be read, the previous rules stay in effect.
+func (rf *ruleFile) current() []fileRule {
+	rf.mu.Lock()
+	defer rf.mu.Unlock()
+	info, err := os.Stat(rf.path)
+	if err != nil {
+		rf.log.Error("checking rule file", zap.String("path", rf.path), zap.Error(err))
+		return rf.rules
+	}
+	if info.ModTime().Equal(rf.modTime) {
+		return rf.rules
+	}
+	rules, err := readRuleFile(rf.path)
+	if err != nil {
+		rf.log.Error("reading rule file", zap.String("path", rf.path), zap.Error(err))
+		return rf.rules
+	}
+	rf.log.Info("rule file reloaded", zap.String("path", rf.path), zap.Int("rules", len(rules)))
+	rf.rules, rf.modTime = rules, info.ModTime()
+	return rf.rules
+}
+
+// readRuleFile parses the rule file at name.
+func readRuleFile(name string) ([]fileRule, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var rules []fileRule
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		text, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		rule, err := parseFileRule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, line, err)
+		}
+		rules = append(rules, rule)
+	}
+	return rules, scanner.Err()
+}
+
+// parseFileRule parses the fields of a rule: action, methods, clients and files.
+func parseFileRule(fields []string) (fileRule, error) {
+	if len(fields) < 4 {
+		return fileRule{}, fmt.Errorf("expected action, methods, clients and files")
+	}
+	var rule fileRule
+	switch fields[0] {
+	case "allow":
+		rule.allow = true
+	case "deny":
+	default:
+		return fileRule{}, fmt.Errorf("unrecognized action '%s'", fields[0])
+	}
+	switch fields[1] {
+	case "read":
+		rule.methods = []string{MethodRead}
+	case "write":
+		rule.methods = []string{MethodWrite}
+	case "*":
+	default:
+		return fileRule{}, fmt.Errorf("unrecognized method '%s'", fields[1])
+	}
+	if fields[2] != "*" {
+		clients, err := parsePrefixes(strings.Split(fields[2], ","))
+		if err != nil {
+			return fileRule{}, fmt.Errorf("clients: %v", err)
+		}
+		rule.clients = clients
+	}
+	for _, pattern := range fields[3:] {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fileRule{}, fmt.Errorf("invalid files pattern %s: %v", pattern, err)
+		}
+	}
+	rule.files = fields[3:]
+	return rule, nil
+}
+
+// UnmarshalCaddyfile sets up the authorizer from Caddyfile tokens.
+//
+//	file <path> {
+//	    default allow|deny
+//	}
+func (fa *FileAuthorizer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume authorizer name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	fa.Path = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "default":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fa.Default = d.Val()
+		default:
+			return d.Errf("unrecognized file authorizer option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*FileAuthorizer)(nil)
+	_ Authorizer            = (*FileAuthorizer)(nil)
+	_ caddyfile.Unmarshaler = (*FileAuthorizer)(nil)
+)
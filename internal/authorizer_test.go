@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestParseFileRule(t *testing.T) {
+	for _, tc := range []struct {
+		line    string
+		allow   bool
+		methods []string
+		clients int
+		files   []string
+		err     bool
+	}{
+		{line: "allow read 10.0.0.0/8 *.efi", allow: true, methods: []string{MethodRead}, clients: 1, files: []string{"*.efi"}},
+		{line: "deny write * configs/*", methods: []string{MethodWrite}, files: []string{"configs/*"}},
+		{line: "allow * 192.0.2.1,2001:db8::/32 a b", allow: true, clients: 2, files: []string{"a", "b"}},
+		{line: "allow read *", err: true},
+		{line: "permit read * *", err: true},
+		{line: "allow delete * *", err: true},
+		{line: "allow read 10.0.0.0/33 *", err: true},
+		{line: "allow read * [", err: true},
+	} {
+		rule, err := parseFileRule(strings.Fields(tc.line))
+		if tc.err {
+			if err == nil {
+				t.Errorf("parseFileRule(%q) succeeded, want error", tc.line)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("parseFileRule(%q): %v", tc.line, err)
+			continue
+		}
+		if rule.allow != tc.allow || !slices.Equal(rule.methods, tc.methods) ||
+			len(rule.clients) != tc.clients || !slices.Equal(rule.files, tc.files) {
+			t.Errorf("parseFileRule(%q) = %+v", tc.line, rule)
+		}
+	}
+}
+
+func TestFileAuthorizer(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules")
+	rules := `# boot files for everyone, configs for the build network
+allow read  *            *.efi
+deny  *     *            secure/*
+allow write 10.0.0.0/8   configs/*
+`
+	if err := os.WriteFile(path, []byte(rules), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := readRuleFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fa := &FileAuthorizer{
+		Path:    path,
+		Default: "deny",
+		rules:   &ruleFile{path: path, log: zap.NewNop(), rules: parsed, modTime: info.ModTime()},
+	}
+	for _, tc := range []struct {
+		method   string
+		ip       string
+		filename string
+		foldCase bool
+		want     bool
+	}{
+		{MethodRead, "192.0.2.1", "bootx64.efi", false, true},
+		{MethodRead, "192.0.2.1", "secure/x", false, false},
+		{MethodRead, "192.0.2.1", "SECURE/x", false, false},
+		{MethodRead, "192.0.2.1", "SECURE/x", true, false},
+		{MethodRead, "192.0.2.1", "secure/boot.efi", false, true},
+		{MethodWrite, "10.1.1.1", "configs/a", false, true},
+		{MethodWrite, "192.0.2.1", "configs/a", false, false},
+		{MethodWrite, "10.1.1.1", "CONFIGS/a", false, false},
+		{MethodWrite, "10.1.1.1", "CONFIGS/a", true, true},
+	} {
+		r := &Request{Method: tc.method, Filename: tc.filename, foldCase: tc.foldCase}
+		r.RemoteAddr.IP = net.ParseIP(tc.ip)
+		got, err := fa.Authorize(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("Authorize(%s %s from %s, foldCase=%v) = %v, want %v", tc.method, tc.filename, tc.ip, tc.foldCase, got, tc.want)
+		}
+	}
+}
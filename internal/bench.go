@@ -0,0 +1,278 @@
+package internal
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/dustin/go-humanize"
+)
+
+// TFTP opcodes (RFC 1350, RFC 2347).
+const (
+	opRRQ   = 1
+	opDATA  = 3
+	opACK   = 4
+	opERROR = 5
+	opOACK  = 6
+)
+
+func cmdTFTPBench(fl caddycmd.Flags) (int, error) {
+	addr := fl.Arg(0)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "69")
+	}
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	b := &benchClient{
+		addr:       raddr,
+		file:       fl.Arg(1),
+		mode:       fl.String("mode"),
+		blksize:    fl.Int("blksize"),
+		windowsize: fl.Int("windowsize"),
+		tsize:      fl.Bool("tsize"),
+		timeout:    fl.Duration("timeout"),
+		retries:    fl.Int("retries"),
+		errs:       make(map[string]int),
+	}
+	if b.mode != "octet" && b.mode != "netascii" {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("unsupported transfer mode '%s'", b.mode)
+	}
+	if b.blksize != 0 && (b.blksize < 8 || b.blksize > 65464) {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("blksize must be between 8 and 65464")
+	}
+	if b.windowsize != 0 && (b.windowsize < 1 || b.windowsize > 65535) {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("windowsize must be between 1 and 65535")
+	}
+	clients, requests := fl.Int("clients"), fl.Int("requests")
+	if clients < 1 || requests < 1 {
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("clients and requests must be at least 1")
+	}
+
+	fmt.Fprintf(os.Stderr, "downloading %s from %s %d times with %d concurrent clients\n", b.file, raddr, requests, clients)
+	var (
+		next      atomic.Int64
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		durations []time.Duration
+	)
+	start := time.Now()
+	for range clients {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for next.Add(1) <= int64(requests) {
+				t := time.Now()
+				if b.get() {
+					mu.Lock()
+					durations = append(durations, time.Since(t))
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	ok := len(durations)
+	failed := requests - ok
+	bytes := b.bytes.Load()
+	fmt.Printf("transfers:    %d ok, %d failed (%.1f%% errors)\n", ok, failed, 100*float64(failed)/float64(requests))
+	fmt.Printf("duration:     %s (%.1f transfers/s)\n", elapsed.Round(time.Millisecond), float64(ok)/elapsed.Seconds())
+	fmt.Printf("throughput:   %s in total, %s/s\n", humanize.IBytes(uint64(bytes)), humanize.IBytes(uint64(float64(bytes)/elapsed.Seconds())))
+	fmt.Printf("retransmits:  %d by the clients, %d duplicates from the server\n", b.retransmits.Load(), b.duplicates.Load())
+	if ok > 0 {
+		slices.Sort(durations)
+		pct := func(p float64) time.Duration {
+			return durations[int(p*float64(ok-1))].Round(time.Millisecond)
+		}
+		fmt.Printf("latency:      min %s, p50 %s, p99 %s, max %s\n", pct(0), pct(0.5), pct(0.99), pct(1))
+	}
+	if failed > 0 {
+		fmt.Println("errors:")
+		for msg, n := range b.errs {
+			fmt.Printf("  %6d  %s\n", n, msg)
+		}
+		return caddy.ExitCodeFailedStartup, fmt.Errorf("%d of %d transfers failed", failed, requests)
+	}
+	return caddy.ExitCodeSuccess, nil
+}
+
+// errBenchTimeout is returned when the server stops responding.
+var errBenchTimeout = errors.New("timed out waiting for the server")
+
+// benchClient downloads a file over and over, counting the datagrams
+// it had to retransmit, which the client of pin/tftp does not expose.
+type benchClient struct {
+	addr       *net.UDPAddr
+	file       string
+	mode       string
+	blksize    int
+	windowsize int
+	tsize      bool
+	timeout    time.Duration
+	retries    int
+
+	bytes       atomic.Int64
+	retransmits atomic.Int64
+	duplicates  atomic.Int64
+
+	mu   sync.Mutex
+	errs map[string]int
+}
+
+// get downloads the file once and reports whether it succeeded.
+func (b *benchClient) get() bool {
+	err := b.download()
+	if err != nil {
+		b.mu.Lock()
+		b.errs[err.Error()]++
+		b.mu.Unlock()
+	}
+	return err == nil
+}
+
+// download performs a lock-step download of the file (RFC 1350),
+// negotiating the blksize, tsize and windowsize options if set (RFC 2347).
+// If the server agrees to a windowsize, only the last block of each
+// window is acknowledged (RFC 7440).
+func (b *benchClient) download() error {
+	conn, err := net.ListenUDP("udp", nil)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	rrq := []byte{0, opRRQ}
+	rrq = append(append(rrq, b.file...), 0)
+	rrq = append(append(rrq, b.mode...), 0)
+	if b.blksize != 0 {
+		rrq = append(append(rrq, "blksize\x00"...), strconv.Itoa(b.blksize)+"\x00"...)
+	}
+	if b.tsize {
+		rrq = append(rrq, "tsize\x000\x00"...)
+	}
+	if b.windowsize != 0 {
+		rrq = append(append(rrq, "windowsize\x00"...), strconv.Itoa(b.windowsize)+"\x00"...)
+	}
+
+	var (
+		last     = rrq
+		peer     = b.addr
+		tid      *net.UDPAddr
+		blksize  = 512
+		window   = 1
+		pending  = 0     // blocks received since the last ACK
+		gap      = false // a block of the window was lost and acknowledged
+		expected = uint16(1)
+		attempts = 0
+		send     = true
+		buf      = make([]byte, 65536)
+	)
+	for {
+		if send {
+			if _, err := conn.WriteToUDP(last, peer); err != nil {
+				return err
+			}
+		}
+		send = true
+		conn.SetReadDeadline(time.Now().Add(b.timeout))
+		n, from, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			var ne net.Error
+			if !errors.As(err, &ne) || !ne.Timeout() {
+				return err
+			}
+			if attempts == b.retries {
+				return errBenchTimeout
+			}
+			attempts++
+			pending = 0
+			b.retransmits.Add(1)
+			continue
+		}
+		if tid == nil && from.IP.Equal(b.addr.IP) {
+			tid, peer = from, from
+		}
+		if tid == nil || !from.IP.Equal(tid.IP) || from.Port != tid.Port {
+			send = false
+			continue
+		}
+		if n < 4 {
+			return fmt.Errorf("short packet")
+		}
+		pkt := buf[:n]
+		switch binary.BigEndian.Uint16(pkt) {
+		case opOACK:
+			if expected != 1 {
+				send = false
+				continue
+			}
+			for _, opt := range parseOptions(pkt[2:]) {
+				switch {
+				case strings.EqualFold(opt[0], "blksize"):
+					blksize, _ = strconv.Atoi(opt[1])
+				case strings.EqualFold(opt[0], "windowsize"):
+					window, _ = strconv.Atoi(opt[1])
+					window = max(window, 1)
+				}
+			}
+			last = []byte{0, opACK, 0, 0}
+		case opDATA:
+			block := binary.BigEndian.Uint16(pkt[2:])
+			if block != expected {
+				b.duplicates.Add(1)
+				send = false
+				// a block beyond the expected one means the window lost
+				// one; acknowledging the last block received in order,
+				// once, makes the server resend the window from there
+				if window > 1 && block-expected < 0x8000 && !gap {
+					send, gap, pending = true, true, 0
+				}
+				continue
+			}
+			gap = false
+			b.bytes.Add(int64(n - 4))
+			last = []byte{0, opACK, byte(block >> 8), byte(block)}
+			if n-4 < blksize {
+				// the final ACK is not retransmitted: the server
+				// resends the last block if it gets lost
+				_, err := conn.WriteToUDP(last, peer)
+				return err
+			}
+			expected++
+			pending++
+			send = pending == window
+			if send {
+				pending = 0
+			}
+		case opERROR:
+			return fmt.Errorf("code: %d, message: %s", binary.BigEndian.Uint16(pkt[2:]), strings.TrimRight(string(pkt[4:]), "\x00"))
+		default:
+			return fmt.Errorf("unexpected opcode %d", binary.BigEndian.Uint16(pkt))
+		}
+		attempts = 0
+	}
+}
+
+// parseOptions parses the NUL-terminated name/value pairs of an OACK.
+func parseOptions(p []byte) [][2]string {
+	fields := strings.Split(strings.TrimSuffix(string(p), "\x00"), "\x00")
+	var opts [][2]string
+	for i := 0; i+1 < len(fields); i += 2 {
+		opts = append(opts, [2]string{fields[i], fields[i+1]})
+	}
+	return opts
+}
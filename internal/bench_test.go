@@ -0,0 +1,91 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestBenchWindowsize(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	// a server sending 10 blocks of 8 bytes in windows of 4,
+	// which loses block 6 the first time
+	var acks []uint16
+	done := make(chan error, 1)
+	go func() {
+		buf := make([]byte, 1500)
+		n, client, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			done <- err
+			return
+		}
+		if !bytes.Contains(buf[:n], []byte("windowsize\x004\x00")) {
+			done <- fmt.Errorf("request %q without windowsize", buf[:n])
+			return
+		}
+		conn.WriteToUDP([]byte("\x00\x06blksize\x008\x00windowsize\x004\x00"), client)
+		next, lost := uint16(0), false
+		for next <= 10 {
+			for block := next; block < next+4 && block <= 10 && next > 0; block++ {
+				if block == 6 && !lost {
+					lost = true
+					continue
+				}
+				data := []byte{0, opDATA, 0, byte(block), 1, 2, 3, 4, 5, 6, 7, 8}
+				if block == 10 {
+					data = data[:7]
+				}
+				conn.WriteToUDP(data, client)
+			}
+			n, _, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				done <- err
+				return
+			}
+			if n != 4 || binary.BigEndian.Uint16(buf) != opACK {
+				done <- fmt.Errorf("got %q, want an ACK", buf[:n])
+				return
+			}
+			ack := binary.BigEndian.Uint16(buf[2:])
+			acks = append(acks, ack)
+			next = ack + 1
+		}
+		done <- nil
+	}()
+
+	b := &benchClient{
+		addr:       conn.LocalAddr().(*net.UDPAddr),
+		file:       "file",
+		mode:       "octet",
+		blksize:    8,
+		windowsize: 4,
+		timeout:    time.Second,
+		retries:    2,
+		errs:       make(map[string]int),
+	}
+	if err := b.download(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if want := []uint16{0, 4, 5, 9, 10}; !slices.Equal(acks, want) {
+		t.Errorf("acknowledged blocks %v, want %v", acks, want)
+	}
+	if got := b.bytes.Load(); got != 9*8+3 {
+		t.Errorf("received %d bytes, want %d", got, 9*8+3)
+	}
+	if got := b.retransmits.Load(); got != 0 {
+		t.Errorf("%d retransmits, want none", got)
+	}
+}
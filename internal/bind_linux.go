@@ -0,0 +1,24 @@
+//go:build linux
+
+package internal
+
+import (
+	"syscall"
+)
+
+// canBindToDevice reports whether sockets can be bound to an interface.
+const canBindToDevice = true
+
+// bindToDevice returns a socket control function that binds sockets
+// to the network interface iface with SO_BINDTODEVICE.
+func bindToDevice(iface string) func(network, address string, c syscall.RawConn) error {
+	return func(_, _ string, c syscall.RawConn) error {
+		var err error
+		if cerr := c.Control(func(fd uintptr) {
+			err = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, iface)
+		}); cerr != nil {
+			return cerr
+		}
+		return err
+	}
+}
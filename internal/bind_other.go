@@ -0,0 +1,19 @@
+//go:build !linux
+
+package internal
+
+import (
+	"errors"
+	"syscall"
+)
+
+// canBindToDevice reports whether sockets can be bound to an interface.
+const canBindToDevice = false
+
+// bindToDevice returns a socket control function that fails,
+// as binding to an interface is not supported on this platform.
+func bindToDevice(string) func(network, address string, c syscall.RawConn) error {
+	return func(string, string, syscall.RawConn) error {
+		return errors.New("binding to an interface is not supported on this platform")
+	}
+}
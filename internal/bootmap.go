@@ -0,0 +1,141 @@
+package internal
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(BootMap{})
+}
+
+// BootMap rewrites well-known boot file names to the files to actually
+// serve, e.g. to serve a different bootx64.efi to the ARM and x86 parts
+// of a fleet from a single server. The first mapping that matches a
+// request rewrites its file name for the next handlers; requests no
+// mapping matches pass through unchanged.
+type BootMap struct {
+	// The mappings, in order.
+	Mappings []BootMapping `json:"mappings,omitempty"`
+
+	log *zap.Logger
+}
+
+// BootMapping maps matching request names to another file.
+type BootMapping struct {
+	// Glob patterns of the requested file names, matched like the hide
+	// patterns of the server, e.g. "bootx64.efi" or "undionly.kpxe".
+	Files []string `json:"files,omitempty"`
+
+	// IP addresses or CIDR ranges of the clients the mapping applies to.
+	// Default is all clients.
+	Clients []string `json:"clients,omitempty"`
+
+	// The file to serve instead. Placeholders are supported,
+	// e.g. "uefi/x64/{tftp.request.file}".
+	To string `json:"to,omitempty"`
+
+	clients []netip.Prefix
+}
+
+// CaddyModule returns the Caddy module information.
+func (BootMap) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.boot_map",
+		New: func() caddy.Module { return new(BootMap) },
+	}
+}
+
+// Provision validates the mappings.
+func (bm *BootMap) Provision(ctx caddy.Context) error {
+	bm.log = ctx.Logger()
+	for i := range bm.Mappings {
+		m := &bm.Mappings[i]
+		if len(m.Files) == 0 || m.To == "" {
+			return fmt.Errorf("mapping %d: files and to are required", i)
+		}
+		for _, pattern := range m.Files {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("mapping %d: invalid pattern %s: %v", i, pattern, err)
+			}
+		}
+		clients, err := parsePrefixes(m.Clients)
+		if err != nil {
+			return fmt.Errorf("mapping %d: clients: %v", i, err)
+		}
+		m.clients = clients
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (bm *BootMap) ServeTFTP(r *Request, next Handler) error {
+	for _, m := range bm.Mappings {
+		if !matchPath(m.Files, r.Filename) {
+			continue
+		}
+		if len(m.clients) > 0 && !allowedBy(r.RemoteAddr.IP, m.clients, nil) {
+			continue
+		}
+		to := r.Replacer().ReplaceAll(m.To, "")
+		bm.log.Debug(
+			"mapped boot file",
+			zap.String("transfer_id", r.ID),
+			zap.String("filename", r.Filename),
+			zap.String("result", to),
+		)
+		r.Filename = to
+		break
+	}
+	return next.ServeTFTP(r)
+}
+
+// UnmarshalCaddyfile sets up the boot map from Caddyfile tokens.
+// Each map line is a mapping, with the patterns of the files to map,
+// the file to serve instead and the clients it applies to, if not all.
+//
+//	boot_map {
+//	    map <patterns...> to <file> [from <cidrs...>]
+//	}
+func (bm *BootMap) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if d.Val() != "map" {
+			return d.Errf("unrecognized boot_map option '%s'", d.Val())
+		}
+		var m BootMapping
+		for d.NextArg() && d.Val() != "to" {
+			m.Files = append(m.Files, d.Val())
+		}
+		if len(m.Files) == 0 || d.Val() != "to" || !d.NextArg() {
+			return d.ArgErr()
+		}
+		m.To = d.Val()
+		if d.NextArg() {
+			if d.Val() != "from" {
+				return d.Errf("expected 'from', got '%s'", d.Val())
+			}
+			m.Clients = d.RemainingArgs()
+			if len(m.Clients) == 0 {
+				return d.ArgErr()
+			}
+		}
+		bm.Mappings = append(bm.Mappings, m)
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*BootMap)(nil)
+	_ MiddlewareHandler     = (*BootMap)(nil)
+	_ caddyfile.Unmarshaler = (*BootMap)(nil)
+)
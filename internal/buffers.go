@@ -0,0 +1,49 @@
+package internal
+
+import (
+	"bufio"
+	"io"
+	"sync"
+)
+
+// bufferSize is the size of the buffers that read ahead of downloads
+// and write behind uploads, so that files are accessed in large chunks
+// instead of one block at a time.
+const bufferSize = 64 << 10
+
+// The buffers are pooled, as a boot storm easily starts hundreds of
+// transfers at once.
+var (
+	readerPool = sync.Pool{
+		New: func() any { return bufio.NewReaderSize(nil, bufferSize) },
+	}
+	writerPool = sync.Pool{
+		New: func() any { return bufio.NewWriterSize(nil, bufferSize) },
+	}
+)
+
+// getReader returns a pooled buffered reader of r;
+// it must be returned with putReader.
+func getReader(r io.Reader) *bufio.Reader {
+	br := readerPool.Get().(*bufio.Reader)
+	br.Reset(r)
+	return br
+}
+
+func putReader(br *bufio.Reader) {
+	br.Reset(nil)
+	readerPool.Put(br)
+}
+
+// getWriter returns a pooled buffered writer to w;
+// it must be returned with putWriter.
+func getWriter(w io.Writer) *bufio.Writer {
+	bw := writerPool.Get().(*bufio.Writer)
+	bw.Reset(w)
+	return bw
+}
+
+func putWriter(bw *bufio.Writer) {
+	bw.Reset(nil)
+	writerPool.Put(bw)
+}
@@ -0,0 +1,108 @@
+package internal
+
+import (
+	"container/list"
+	"io/fs"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// FileCache configures the in-memory cache of a file server.
+type FileCache struct {
+	// The maximum total size of the cached files in bytes;
+	// the least recently used files are evicted beyond it.
+	// Default is 64 MiB.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	// The maximum size of a file to cache in bytes; larger files
+	// are always read from disk. Default is max_size.
+	MaxFileSize int64 `json:"max_file_size,omitempty"`
+}
+
+// fileCache keeps the contents of recently served files in memory,
+// evicting the least recently used ones beyond its size limit.
+type fileCache struct {
+	maxSize     int64
+	maxFileSize int64
+
+	mu    sync.Mutex
+	size  int64
+	lru   list.List // of *cacheEntry, most recently used first
+	m     map[string]*list.Element
+	loads singleflight.Group
+}
+
+type cacheEntry struct {
+	key     string
+	modTime time.Time
+	size    int64 // of the file on disk
+	data    []byte
+}
+
+func newFileCache(fc *FileCache) *fileCache {
+	c := &fileCache{
+		maxSize:     fc.MaxSize,
+		maxFileSize: fc.MaxFileSize,
+		m:           make(map[string]*list.Element),
+	}
+	if c.maxSize <= 0 {
+		c.maxSize = 64 << 20
+	}
+	if c.maxFileSize <= 0 || c.maxFileSize > c.maxSize {
+		c.maxFileSize = c.maxSize
+	}
+	return c
+}
+
+// get returns the contents of the file identified by key, which is
+// described by info, calling load if it is not cached or changed since.
+// Concurrent calls for the same file share a single load.
+func (c *fileCache) get(key string, info fs.FileInfo, load func() ([]byte, error)) ([]byte, error) {
+	c.mu.Lock()
+	if e, ok := c.m[key]; ok {
+		ce := e.Value.(*cacheEntry)
+		if ce.modTime.Equal(info.ModTime()) && ce.size == info.Size() {
+			c.lru.MoveToFront(e)
+			c.mu.Unlock()
+			return ce.data, nil
+		}
+		c.remove(e)
+	}
+	c.mu.Unlock()
+
+	version := key + "@" + strconv.FormatInt(info.ModTime().UnixNano(), 10)
+	v, err, _ := c.loads.Do(version, func() (any, error) {
+		data, err := load()
+		if err != nil {
+			return nil, err
+		}
+		c.add(&cacheEntry{key: key, modTime: info.ModTime(), size: info.Size(), data: data})
+		return data, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return v.([]byte), nil
+}
+
+func (c *fileCache) add(ce *cacheEntry) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.m[ce.key]; ok {
+		c.remove(e)
+	}
+	c.m[ce.key] = c.lru.PushFront(ce)
+	c.size += int64(len(ce.data))
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back())
+	}
+}
+
+func (c *fileCache) remove(e *list.Element) {
+	ce := c.lru.Remove(e).(*cacheEntry)
+	delete(c.m, ce.key)
+	c.size -= int64(len(ce.data))
+}
@@ -0,0 +1,627 @@
+package internal
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/dustin/go-humanize"
+)
+
+func init() {
+	httpcaddyfile.RegisterGlobalOption("tftp", parseGlobalOption)
+}
+
+// parseGlobalOption sets up the TFTP app from the global options block.
+//
+//	{
+//	    tftp {
+//	        grace_period <duration>
+//	        server [<name>] {
+//	            listen  <addresses...>
+//	            listen_sockets <n>
+//	            root    <path>
+//	            client_root <path> <cidrs...>
+//	            timeout <duration>
+//	            retries <n>
+//	            backoff <duration>
+//	            max_transfer_duration <duration>
+//	            idle_timeout          <duration>
+//	            block_size <size>
+//	            min_block_size <size>
+//	            anticipate <blocks>
+//	            single_port
+//	            strict
+//	            dscp    <value>
+//	            read_buffer  <size>
+//	            write_buffer <size>
+//	            bind_interface <name>
+//	            log_level debug|info|warn|error
+//	            logs {
+//	                logger_name <name>
+//	                include <fields...>
+//	                exclude <fields...>
+//	                options
+//	                field   <name> <value>
+//	                sample  <n>
+//	            }
+//	            file_stats {
+//	                max_files <n>
+//	                rollover  <duration>
+//	            }
+//	            tracing {
+//	                span <name>
+//	            }
+//	            debug_packets [<cidrs...>]
+//	            read_only
+//	            write_only
+//	            overwrite deny|allow|version
+//	            symlinks  follow|deny|deny_escape
+//	            convert_backslashes
+//	            case_insensitive
+//	            max_upload_size   <size>
+//	            max_download_size <size>
+//	            quota {
+//	                max_bytes_per_client <size>
+//	                max_files_per_client <n>
+//	                max_bytes_per_root   <size>
+//	                max_files_per_root   <n>
+//	                max_bytes  <size>
+//	                max_files  <n>
+//	                state_file <path>
+//	            }
+//	            allow   <cidrs...>
+//	            deny    <cidrs...>
+//	            hide    <patterns...>
+//	            hide_hidden true|false
+//	            allowed_extensions <extensions...>
+//	            denied_extensions  <extensions...>
+//	            health_file <name>
+//	            access_rule {
+//	                files   <patterns...>
+//	                methods read|write...
+//	                allow   <cidrs...>
+//	                deny    <cidrs...>
+//	            }
+//	            authorize <authorizer> [<args...>] {
+//	                ...
+//	            }
+//	            max_rate_per_transfer <size>
+//	            max_rate_per_client   <size>
+//	            max_requests_per_client <rate> [<burst>]
+//	            max_concurrent_transfers <n>
+//	            max_queued_transfers     <n>
+//
+//	            <handler> [<args...>] {
+//	                ...
+//	            }
+//	        }
+//	    }
+//	}
+func parseGlobalOption(d *caddyfile.Dispenser, existingVal any) (any, error) {
+	app := new(TFTP)
+	if existingVal != nil {
+		appConfig, ok := existingVal.(httpcaddyfile.App)
+		if !ok {
+			return nil, d.Errf("existing tftp value of unexpected type: %T", existingVal)
+		}
+		if err := json.Unmarshal(appConfig.Value, app); err != nil {
+			return nil, d.WrapErr(err)
+		}
+	}
+
+	if err := app.UnmarshalCaddyfile(d); err != nil {
+		return nil, err
+	}
+
+	return httpcaddyfile.App{
+		Name:  "tftp",
+		Value: caddyconfig.JSON(app, nil),
+	}, nil
+}
+
+// UnmarshalCaddyfile sets up the TFTP app from Caddyfile tokens.
+func (app *TFTP) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume app name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "server":
+			name := ""
+			if d.NextArg() {
+				name = d.Val()
+			}
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			if app.Servers == nil {
+				app.Servers = make(map[string]*Server)
+			}
+			if _, ok := app.Servers[name]; ok {
+				return d.Errf("duplicate server name: %s", strconv.Quote(name))
+			}
+			srv := new(Server)
+			if err := srv.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+				return err
+			}
+			app.Servers[name] = srv
+		case "grace_period":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing grace_period duration: %v", err)
+			}
+			app.GracePeriod = caddy.Duration(dur)
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unrecognized tftp option '%s'", d.Val())
+		}
+	}
+	return nil
+}
+
+// UnmarshalCaddyfile sets up a TFTP server from Caddyfile tokens.
+func (srv *Server) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume block opener
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "listen":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			srv.Listen = append(srv.Listen, args...)
+		case "listen_sockets":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing listen_sockets: %v", err)
+			}
+			srv.ListenSockets = n
+		case "root":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			srv.Root = d.Val()
+		case "client_root":
+			args := d.RemainingArgs()
+			if len(args) < 2 {
+				return d.ArgErr()
+			}
+			srv.ClientRoots = append(srv.ClientRoots, ClientRoot{Root: args[0], Clients: args[1:]})
+			continue
+		case "timeout", "backoff", "max_transfer_duration", "idle_timeout":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s duration: %v", name, err)
+			}
+			switch name {
+			case "timeout":
+				srv.Timeout = caddy.Duration(dur)
+			case "backoff":
+				srv.Backoff = caddy.Duration(dur)
+			case "max_transfer_duration":
+				srv.MaxTransferDuration = caddy.Duration(dur)
+			case "idle_timeout":
+				srv.IdleTimeout = caddy.Duration(dur)
+			}
+		case "retries":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing retries: %v", err)
+			}
+			srv.Retries = n
+		case "block_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing block_size: %v", err)
+			}
+			srv.BlockSize = size
+		case "min_block_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing min_block_size: %v", err)
+			}
+			srv.MinBlockSize = size
+		case "anticipate":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.ParseUint(d.Val(), 10, 0)
+			if err != nil {
+				return d.Errf("parsing anticipate: %v", err)
+			}
+			srv.Anticipate = uint(n)
+		case "window_size":
+			// rejected explicitly, as it would otherwise be taken for
+			// the name of a handler
+			return d.Err("window_size is not supported, as the pin/tftp library does not negotiate the windowsize option (RFC 7440); see anticipate")
+		case "single_port":
+			srv.SinglePort = true
+		case "strict":
+			srv.Strict = true
+		case "dscp":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing dscp: %v", err)
+			}
+			srv.DSCP = n
+		case "read_buffer", "write_buffer":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s: %v", name, err)
+			}
+			if name == "read_buffer" {
+				srv.ReadBuffer = int(size)
+			} else {
+				srv.WriteBuffer = int(size)
+			}
+		case "log_level":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			srv.LogLevel = d.Val()
+		case "bind_interface":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			srv.BindInterface = d.Val()
+		case "logs":
+			logs := new(AccessLogs)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "logger_name":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					logs.LoggerName = d.Val()
+				case "include":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					logs.Include = append(logs.Include, args...)
+				case "exclude":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					logs.Exclude = append(logs.Exclude, args...)
+				case "options":
+					logs.Options = true
+				case "field":
+					var key, value string
+					if !d.Args(&key, &value) {
+						return d.ArgErr()
+					}
+					if logs.Fields == nil {
+						logs.Fields = make(map[string]string)
+					}
+					logs.Fields[key] = value
+				case "sample":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					n, err := strconv.ParseInt(d.Val(), 10, 64)
+					if err != nil {
+						return d.Errf("parsing sample: %v", err)
+					}
+					logs.Sample = n
+				default:
+					return d.Errf("unrecognized logs option '%s'", d.Val())
+				}
+			}
+			srv.Logs = logs
+		case "file_stats":
+			stats := new(FileStats)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "max_files":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					n, err := strconv.Atoi(d.Val())
+					if err != nil {
+						return d.Errf("parsing max_files: %v", err)
+					}
+					stats.MaxFiles = n
+				case "rollover":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					dur, err := caddy.ParseDuration(d.Val())
+					if err != nil {
+						return d.Errf("parsing rollover duration: %v", err)
+					}
+					stats.Rollover = caddy.Duration(dur)
+				default:
+					return d.Errf("unrecognized file_stats option '%s'", d.Val())
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			}
+			srv.FileStats = stats
+		case "tracing":
+			tracing := new(Tracing)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "span":
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					tracing.Span = d.Val()
+				default:
+					return d.Errf("unrecognized tracing option '%s'", d.Val())
+				}
+			}
+			srv.Tracing = tracing
+		case "debug_packets":
+			srv.DebugPackets = &PacketDebug{Clients: d.RemainingArgs()}
+		case "convert_backslashes":
+			srv.ConvertBackslashes = true
+		case "case_insensitive":
+			srv.CaseInsensitive = true
+		case "read_only":
+			srv.ReadOnly = true
+		case "write_only":
+			srv.WriteOnly = true
+		case "overwrite":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			srv.Overwrite = d.Val()
+		case "symlinks":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			srv.Symlinks = d.Val()
+		case "health_file":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			srv.HealthFile = d.Val()
+		case "allow":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			srv.Allow = append(srv.Allow, args...)
+		case "deny":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			srv.Deny = append(srv.Deny, args...)
+		case "hide":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			srv.Hide = append(srv.Hide, args...)
+		case "hide_hidden":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			hideHidden, err := strconv.ParseBool(d.Val())
+			if err != nil {
+				return d.Errf("parsing hide_hidden: %v", err)
+			}
+			srv.HideHidden = &hideHidden
+		case "allowed_extensions", "denied_extensions":
+			name := d.Val()
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			if name == "allowed_extensions" {
+				srv.AllowedExtensions = append(srv.AllowedExtensions, args...)
+			} else {
+				srv.DeniedExtensions = append(srv.DeniedExtensions, args...)
+			}
+		case "access_rule":
+			var rule AccessRule
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "files":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					rule.Files = append(rule.Files, args...)
+				case "methods":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					rule.Methods = append(rule.Methods, args...)
+				case "allow":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					rule.Allow = append(rule.Allow, args...)
+				case "deny":
+					args := d.RemainingArgs()
+					if len(args) == 0 {
+						return d.ArgErr()
+					}
+					rule.Deny = append(rule.Deny, args...)
+				default:
+					return d.Errf("unrecognized access_rule option '%s'", d.Val())
+				}
+			}
+			srv.AccessRules = append(srv.AccessRules, rule)
+		case "authorize":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			if srv.AuthorizeRaw != nil {
+				return d.Err("authorize may only be given once per server")
+			}
+			authorizerName := d.Val()
+			modID := "tftp.authorizers." + authorizerName
+			unm, err := caddyfile.UnmarshalModule(d, modID)
+			if err != nil {
+				return err
+			}
+			srv.AuthorizeRaw = caddyconfig.JSONModuleObject(unm, "authorizer", authorizerName, nil)
+			continue
+		case "max_concurrent_transfers", "max_queued_transfers":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s: %v", name, err)
+			}
+			if name == "max_concurrent_transfers" {
+				srv.MaxConcurrentTransfers = n
+			} else {
+				srv.MaxQueuedTransfers = n
+			}
+		case "max_upload_size", "max_download_size":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s: %v", name, err)
+			}
+			if name == "max_upload_size" {
+				srv.MaxUploadSize = int64(size)
+			} else {
+				srv.MaxDownloadSize = int64(size)
+			}
+		case "quota":
+			quota := new(Quota)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				name := d.Val()
+				if !d.NextArg() {
+					return d.ArgErr()
+				}
+				switch name {
+				case "max_bytes_per_client", "max_bytes_per_root", "max_bytes":
+					size, err := humanize.ParseBytes(d.Val())
+					if err != nil {
+						return d.Errf("parsing %s: %v", name, err)
+					}
+					switch name {
+					case "max_bytes_per_client":
+						quota.MaxBytesPerClient = int64(size)
+					case "max_bytes_per_root":
+						quota.MaxBytesPerRoot = int64(size)
+					default:
+						quota.MaxBytes = int64(size)
+					}
+				case "max_files_per_client", "max_files_per_root", "max_files":
+					n, err := strconv.ParseInt(d.Val(), 10, 64)
+					if err != nil {
+						return d.Errf("parsing %s: %v", name, err)
+					}
+					switch name {
+					case "max_files_per_client":
+						quota.MaxFilesPerClient = n
+					case "max_files_per_root":
+						quota.MaxFilesPerRoot = n
+					default:
+						quota.MaxFiles = n
+					}
+				case "state_file":
+					quota.StateFile = d.Val()
+				default:
+					return d.Errf("unrecognized quota option '%s'", name)
+				}
+				if d.NextArg() {
+					return d.ArgErr()
+				}
+			}
+			srv.Quota = quota
+		case "max_rate_per_transfer", "max_rate_per_client":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s: %v", name, err)
+			}
+			if name == "max_rate_per_transfer" {
+				srv.MaxRatePerTransfer = int64(size)
+			} else {
+				srv.MaxRatePerClient = int64(size)
+			}
+		case "max_requests_per_client":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			perSecond, err := strconv.ParseFloat(d.Val(), 64)
+			if err != nil {
+				return d.Errf("parsing max_requests_per_client: %v", err)
+			}
+			srv.MaxRequestsPerClient = perSecond
+			if d.NextArg() {
+				burst, err := strconv.Atoi(d.Val())
+				if err != nil {
+					return d.Errf("parsing max_requests_per_client burst: %v", err)
+				}
+				srv.RequestBurst = burst
+			}
+		default:
+			handlerName := d.Val()
+			modID := "tftp.handlers." + handlerName
+			if _, err := caddy.GetModule(modID); err != nil {
+				return d.Errf("unrecognized server option '%s'", handlerName)
+			}
+			unm, err := caddyfile.UnmarshalModule(d, modID)
+			if err != nil {
+				return err
+			}
+			srv.HandlersRaw = append(srv.HandlersRaw, caddyconfig.JSONModuleObject(unm, "handler", handlerName, nil))
+			continue
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddyfile.Unmarshaler = (*TFTP)(nil)
+	_ caddyfile.Unmarshaler = (*Server)(nil)
+)
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+// equalJSON reports whether a and b hold the same JSON value.
+func equalJSON(t *testing.T, a, b []byte) bool {
+	t.Helper()
+	var va, vb any
+	if err := json.Unmarshal(a, &va); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(b, &vb); err != nil {
+		t.Fatal(err)
+	}
+	return reflect.DeepEqual(va, vb)
+}
+
+func TestGlobalOption(t *testing.T) {
+	v, err := parseGlobalOption(caddyfile.NewTestDispenser(`tftp {
+		grace_period 10s
+		server pxe {
+			listen :69 [::]:69
+			root /srv/tftp
+			timeout 2s
+			retries 3
+			block_size 1428
+			read_only
+			allow 10.0.0.0/8
+			quota {
+				max_files_per_root 10
+			}
+			file_server
+		}
+	}`), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := v.(httpcaddyfile.App)
+	want := `{
+		"grace_period": 10000000000,
+		"servers": {
+			"pxe": {
+				"listen": [":69", "[::]:69"],
+				"root": "/srv/tftp",
+				"timeout": 2000000000,
+				"retries": 3,
+				"block_size": 1428,
+				"read_only": true,
+				"quota": {"max_files_per_root": 10},
+				"allow": ["10.0.0.0/8"],
+				"handle": [{"handler": "file_server"}]
+			}
+		}
+	}`
+	if app.Name != "tftp" || !equalJSON(t, app.Value, []byte(want)) {
+		t.Fatalf("got %s %s, want %s", app.Name, app.Value, want)
+	}
+
+	// the JSON config reads back into the same app
+	var tftpApp TFTP
+	if err := json.Unmarshal(app.Value, &tftpApp); err != nil {
+		t.Fatal(err)
+	}
+	if got := caddyconfig.JSON(&tftpApp, nil); !equalJSON(t, got, app.Value) {
+		t.Errorf("round trip: got %s, want %s", got, app.Value)
+	}
+
+	// another tftp block adds to the app
+	v, err = parseGlobalOption(caddyfile.NewTestDispenser(`tftp {
+		server uploads {
+			listen :1069
+		}
+	}`), app)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var merged TFTP
+	if err := json.Unmarshal(v.(httpcaddyfile.App).Value, &merged); err != nil {
+		t.Fatal(err)
+	}
+	if len(merged.Servers) != 2 || merged.Servers["pxe"] == nil || merged.Servers["uploads"] == nil || merged.GracePeriod != tftpApp.GracePeriod {
+		t.Errorf("merged: %s", v.(httpcaddyfile.App).Value)
+	}
+	_, err = parseGlobalOption(caddyfile.NewTestDispenser(`tftp {
+		server pxe
+	}`), app)
+	if err == nil || !strings.Contains(err.Error(), "duplicate server name") {
+		t.Errorf("got %v, want a duplicate server name", err)
+	}
+}
+
+func TestTFTPCaddyfileErrors(t *testing.T) {
+	for _, input := range []string{
+		`tftp extra`,
+		`tftp {
+			grace_period
+		}`,
+		`tftp {
+			grace_period soon
+		}`,
+		`tftp {
+			unknown
+		}`,
+		`tftp {
+			server a b
+		}`,
+		`tftp {
+			server {
+				timeout 1s 2s
+			}
+		}`,
+		`tftp {
+			server {
+				retries many
+			}
+		}`,
+		`tftp {
+			server {
+				no_such_handler
+			}
+		}`,
+	} {
+		app := new(TFTP)
+		if err := app.UnmarshalCaddyfile(caddyfile.NewTestDispenser(input)); err == nil {
+			t.Errorf("%s: succeeded, want error", input)
+		}
+	}
+}
+
+func TestServerCaddyfileWindowSize(t *testing.T) {
+	srv := new(Server)
+	err := srv.UnmarshalCaddyfile(caddyfile.NewTestDispenser(`server {
+		window_size 8
+	}`))
+	if err == nil || !strings.Contains(err.Error(), "window_size is not supported") {
+		t.Errorf("got %v, want window_size to be rejected", err)
+	}
+}
@@ -0,0 +1,90 @@
+package internal
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksumExt is the extension of the sidecar file holding
+// the SHA-256 checksum of a file, in sha256sum format.
+const checksumExt = ".sha256"
+
+// errChecksumMismatch is returned for downloads whose contents do not
+// match their checksum sidecar.
+var errChecksumMismatch = errors.New("checksum mismatch")
+
+// sha256File returns the hex-encoded SHA-256 checksum of the contents of r.
+func sha256File(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// writeChecksum computes the checksum of the file name in rt and
+// stores it in its sidecar file.
+func writeChecksum(rt *os.Root, name string) (string, error) {
+	file, err := rt.Open(name)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	sum, err := sha256File(file)
+	if err != nil {
+		return "", err
+	}
+	line := sum + "  " + filepath.Base(name) + "\n"
+	return sum, rt.WriteFile(name+checksumExt, []byte(line), 0644)
+}
+
+// readChecksum returns the checksum stored in the sidecar file of name,
+// reporting false if there is none.
+func readChecksum(open func(string) (fs.File, error), name string) (string, bool, error) {
+	file, err := open(name + checksumExt)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", false, nil
+	} else if err != nil {
+		return "", false, err
+	}
+	defer file.Close()
+	line, err := bufio.NewReader(io.LimitReader(file, 4096)).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", false, err
+	}
+	fields := strings.Fields(line)
+	if len(fields) == 0 {
+		return "", false, fmt.Errorf("empty checksum file %s", name+checksumExt)
+	}
+	return strings.ToLower(fields[0]), true, nil
+}
+
+// verifyChecksum compares the checksum of the file name with the one
+// in its sidecar file, if there is one.
+func verifyChecksum(open func(string) (fs.File, error), name string) error {
+	want, ok, err := readChecksum(open, name)
+	if err != nil || !ok {
+		return err
+	}
+	file, err := open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	got, err := sha256File(file)
+	if err != nil {
+		return err
+	}
+	if got != want {
+		return errChecksumMismatch
+	}
+	return nil
+}
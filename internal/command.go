@@ -0,0 +1,181 @@
+package internal
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	caddycmd "github.com/caddyserver/caddy/v2/cmd"
+	"github.com/pin/tftp/v3"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	caddycmd.RegisterCommand(caddycmd.Command{
+		Name:  "tftp",
+		Short: "Transfers files with a TFTP server",
+		Long: `
+Downloads files from or uploads files to a TFTP server, e.g. to smoke-test
+a server without installing a separate client.
+
+The server address may omit the port, which defaults to 69.`,
+		CobraFunc: func(cmd *cobra.Command) {
+			get := &cobra.Command{
+				Use:   "get [--blksize <n>] [--tsize] [--mode octet|netascii] <server> <file> [<local>]",
+				Short: "Downloads a file, to stdout if <local> is -",
+				Long: `
+Downloads <file> from <server> and writes it to <local>,
+which defaults to the last element of <file>. A <local> of -
+writes the file to stdout.`,
+				Args: cobra.RangeArgs(2, 3),
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdTFTPGet),
+			}
+			put := &cobra.Command{
+				Use:   "put [--blksize <n>] [--tsize] [--mode octet|netascii] <server> <file> [<local>]",
+				Short: "Uploads a file, from stdin if <local> is -",
+				Long: `
+Uploads <local> to <server> as <file>. <local> defaults to <file>,
+and a <local> of - uploads what is read from stdin.`,
+				Args: cobra.RangeArgs(2, 3),
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdTFTPPut),
+			}
+			bench := &cobra.Command{
+				Use:   "bench [--clients <n>] [--requests <n>] [--blksize <n>] [--windowsize <n>] [--tsize] <server> <file>",
+				Short: "Downloads a file with many concurrent clients",
+				Long: `
+Downloads <file> from <server> --requests times with --clients concurrent
+clients, and reports the throughput, the retransmitted datagrams, the
+latency and the errors of the transfers. Exits with an error if any
+transfer failed.`,
+				Args: cobra.ExactArgs(2),
+				RunE: caddycmd.WrapCommandFuncForCobra(cmdTFTPBench),
+			}
+			bench.Flags().IntP("clients", "c", 10, "Number of concurrent clients")
+			bench.Flags().IntP("requests", "n", 100, "Number of downloads in total")
+			bench.Flags().IntP("windowsize", "w", 0, "Window size to request (RFC 7440), default 1")
+			for _, c := range []*cobra.Command{get, put} {
+				c.Flags().IntP("windowsize", "w", 0, "Window size (RFC 7440); only 1 is supported")
+			}
+			for _, c := range []*cobra.Command{get, put, bench} {
+				c.Flags().IntP("blksize", "b", 0, "Block size to request (RFC 2348), default 512")
+				c.Flags().BoolP("tsize", "s", false, "Request the transfer size (RFC 2349)")
+				c.Flags().StringP("mode", "m", "octet", "Transfer mode: octet or netascii")
+				c.Flags().DurationP("timeout", "t", 5*time.Second, "Time to wait for each packet")
+				c.Flags().IntP("retries", "r", 5, "Number of times to resend a packet")
+				cmd.AddCommand(c)
+			}
+		},
+	})
+}
+
+func cmdTFTPGet(fl caddycmd.Flags) (int, error) {
+	client, err := newTFTPClient(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	file := fl.Arg(1)
+	local := path.Base(file)
+	if fl.NArg() > 2 {
+		local = fl.Arg(2)
+	}
+
+	start := time.Now()
+	wt, err := client.Receive(file, fl.String("mode"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	// the transfer forgets the negotiated options once it starts
+	size := ""
+	if it, ok := wt.(tftp.IncomingTransfer); ok {
+		if tsize, ok := it.Size(); ok {
+			size = fmt.Sprintf(" (tsize %d)", tsize)
+		}
+	}
+	var w io.Writer = os.Stdout
+	if local != "-" {
+		f, err := os.Create(local)
+		if err != nil {
+			return caddy.ExitCodeFailedStartup, err
+		}
+		defer f.Close()
+		w = f
+	}
+	n, err := wt.WriteTo(w)
+	if err != nil {
+		if local != "-" {
+			os.Remove(local)
+		}
+		return caddy.ExitCodeFailedStartup, err
+	}
+	fmt.Fprintf(os.Stderr, "received %d bytes%s in %s\n", n, size, time.Since(start).Round(time.Millisecond))
+	return caddy.ExitCodeSuccess, nil
+}
+
+func cmdTFTPPut(fl caddycmd.Flags) (int, error) {
+	client, err := newTFTPClient(fl)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	file := fl.Arg(1)
+	local := file
+	if fl.NArg() > 2 {
+		local = fl.Arg(2)
+	}
+
+	var r io.Reader = os.Stdin
+	if local != "-" {
+		f, err := os.Open(local)
+		if err != nil {
+			return caddy.ExitCodeFailedStartup, err
+		}
+		defer f.Close()
+		r = f
+	}
+	start := time.Now()
+	rf, err := client.Send(file, fl.String("mode"))
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	n, err := rf.ReadFrom(r)
+	if err != nil {
+		return caddy.ExitCodeFailedStartup, err
+	}
+	fmt.Fprintf(os.Stderr, "sent %d bytes in %s\n", n, time.Since(start).Round(time.Millisecond))
+	return caddy.ExitCodeSuccess, nil
+}
+
+// newTFTPClient returns a client for the server given as first
+// argument, configured by the flags shared by get and put.
+func newTFTPClient(fl caddycmd.Flags) (*tftp.Client, error) {
+	addr := fl.Arg(0)
+	if _, _, err := net.SplitHostPort(addr); err != nil {
+		addr = net.JoinHostPort(addr, "69")
+	}
+	switch mode := fl.String("mode"); mode {
+	case "octet", "netascii":
+	default:
+		return nil, fmt.Errorf("unsupported transfer mode '%s'", mode)
+	}
+	client, err := tftp.NewClient(addr)
+	if err != nil {
+		return nil, err
+	}
+	if blksize := fl.Int("blksize"); blksize != 0 {
+		if blksize < 8 || blksize > 65464 {
+			return nil, fmt.Errorf("blksize must be between 8 and 65464")
+		}
+		client.SetBlockSize(blksize)
+	}
+	// pin/tftp sends and receives lock-step only
+	if windowsize := fl.Int("windowsize"); windowsize != 0 && windowsize != 1 {
+		return nil, fmt.Errorf("windowsize is not supported by get and put, as the client library does not negotiate it; use bench")
+	}
+	client.RequestTSize(fl.Bool("tsize"))
+	client.SetTimeout(fl.Duration("timeout"))
+	client.SetRetries(fl.Int("retries"))
+	return client, nil
+}
@@ -0,0 +1,291 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(ExecHandler{})
+}
+
+// ExecHandler serves downloads by running a command and sending what it
+// writes to stdout, e.g. boot payloads an inventory script generates per
+// host. Besides the environment of Caddy, the command gets:
+//
+//	TFTP_FILENAME     the requested file name
+//	TFTP_CLIENT_IP    the IP address of the client
+//	TFTP_CLIENT_PORT  the port of the client
+//	TFTP_SERVER_IP    the IP address the request was received on
+//	TFTP_SERVER_NAME  the name of the server
+//	TFTP_TRANSFER_ID  the unique ID of the transfer
+//	TFTP_MODE         octet or netascii
+//
+// The output is sent while the command runs, so its size is unknown to
+// the tsize option. A command that fails before writing anything fails
+// the request; one that fails afterwards aborts the transfer. Uploads and
+// downloads of other names are passed to the next handlers.
+type ExecHandler struct {
+	// Glob patterns of the file names to run the command for,
+	// e.g. "*.ipxe". Default is all files.
+	MatchFiles []string `json:"match,omitempty"`
+
+	// The command to run. Placeholders are supported.
+	Command string `json:"command,omitempty"`
+
+	// The arguments of the command. Placeholders are supported.
+	Args []string `json:"args,omitempty"`
+
+	// Additional environment variables of the command.
+	// Placeholders are supported in the values.
+	Env map[string]string `json:"env,omitempty"`
+
+	// The maximum time the command may run before it is killed,
+	// aborting the transfer. Default is 30 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// The maximum size of the output in bytes; the transfer is aborted
+	// once the command writes more. Default is 64 MiB.
+	MaxSize int64 `json:"max_size,omitempty"`
+
+	log *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (ExecHandler) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.exec",
+		New: func() caddy.Module { return new(ExecHandler) },
+	}
+}
+
+// Provision validates the configuration.
+func (e *ExecHandler) Provision(ctx caddy.Context) error {
+	e.log = ctx.Logger()
+	if e.Command == "" {
+		return fmt.Errorf("command is required")
+	}
+	for _, pattern := range e.MatchFiles {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	if e.Timeout <= 0 {
+		e.Timeout = caddy.Duration(30 * time.Second)
+	}
+	if e.MaxSize <= 0 {
+		e.MaxSize = 64 << 20
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (e *ExecHandler) ServeTFTP(r *Request, next Handler) error {
+	if r.Method != MethodRead || (len(e.MatchFiles) > 0 && !matchPath(e.MatchFiles, r.Filename)) {
+		return next.ServeTFTP(r)
+	}
+	repl := r.Replacer()
+	command := repl.ReplaceAll(e.Command, "")
+	args := make([]string, len(e.Args))
+	for i, arg := range e.Args {
+		args[i] = repl.ReplaceAll(arg, "")
+	}
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(e.Timeout))
+	cmd := exec.CommandContext(ctx, command, args...)
+	cmd.Env = append(os.Environ(), e.environ(r)...)
+	// children of the command may keep its stdout and stderr open
+	cmd.WaitDelay = time.Second
+	out := &commandOutput{ctx: ctx, cmd: cmd, name: command, max: e.MaxSize}
+	cmd.Stderr = &out.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		cancel()
+		return err
+	}
+	out.r = stdout
+	e.log.Debug("running command", zap.String("transfer_id", r.ID), zap.String("command", command), zap.Strings("args", args))
+	if err := cmd.Start(); err != nil {
+		cancel()
+		return fmt.Errorf("running %s: %v", command, err)
+	}
+	// unblocks reading the output once the command is killed
+	stop := context.AfterFunc(ctx, func() { _ = stdout.Close() })
+	defer func() {
+		// kills the command if the transfer ended early
+		cancel()
+		stop()
+		_ = out.wait()
+	}()
+
+	br := getReader(out)
+	defer putReader(br)
+	// waiting for the first byte reports a command that fails right
+	// away as a failed request, not as an aborted transfer
+	if _, err := br.Peek(1); err != nil && err != io.EOF {
+		return err
+	}
+	_, err = r.ReadFrom(br)
+	return err
+}
+
+// environ returns the environment variables of the command for r.
+func (e *ExecHandler) environ(r *Request) []string {
+	var serverIP string
+	if r.LocalIP != nil {
+		serverIP = r.LocalIP.String()
+	}
+	env := []string{
+		"TFTP_FILENAME=" + r.Filename,
+		"TFTP_CLIENT_IP=" + r.RemoteAddr.IP.String(),
+		"TFTP_CLIENT_PORT=" + strconv.Itoa(r.RemoteAddr.Port),
+		"TFTP_SERVER_IP=" + serverIP,
+		"TFTP_SERVER_NAME=" + r.server,
+		"TFTP_TRANSFER_ID=" + r.ID,
+		"TFTP_MODE=" + r.Mode,
+	}
+	repl := r.Replacer()
+	for name, value := range e.Env {
+		env = append(env, name+"="+repl.ReplaceAll(value, ""))
+	}
+	return env
+}
+
+// commandOutput reads the stdout of a command. At the end of the output
+// it waits for the command to exit and returns its failure instead of
+// io.EOF, so a transfer is aborted rather than ended with partial output.
+type commandOutput struct {
+	ctx    context.Context
+	cmd    *exec.Cmd
+	name   string
+	r      io.Reader
+	n      int64
+	max    int64
+	stderr stderrBuffer
+	waited bool
+	err    error
+}
+
+func (c *commandOutput) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	if c.n > c.max {
+		return 0, errFileTooLarge
+	}
+	// reading fails once the output is closed for a killed command
+	if err == io.EOF || (err != nil && c.ctx.Err() != nil) {
+		if werr := c.wait(); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// wait waits for the command to exit and returns why it failed, if it did.
+func (c *commandOutput) wait() error {
+	if c.waited {
+		return c.err
+	}
+	c.waited = true
+	err := c.cmd.Wait()
+	switch {
+	case errors.Is(c.ctx.Err(), context.DeadlineExceeded):
+		c.err = fmt.Errorf("running %s: timed out", c.name)
+	case err != nil:
+		c.err = fmt.Errorf("running %s: %v: %s", c.name, err, bytes.TrimSpace(c.stderr.buf.Bytes()))
+	}
+	return c.err
+}
+
+// stderrBuffer keeps the start of what a command writes to stderr,
+// enough to tell why it failed.
+type stderrBuffer struct {
+	buf bytes.Buffer
+}
+
+func (b *stderrBuffer) Write(p []byte) (int, error) {
+	if room := 4096 - b.buf.Len(); room > 0 {
+		b.buf.Write(p[:min(len(p), room)])
+	}
+	return len(p), nil
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+//
+//	exec <command> [<args...>] {
+//	    match    <patterns...>
+//	    env      <name> <value>
+//	    timeout  <duration>
+//	    max_size <size>
+//	}
+func (e *ExecHandler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	e.Command = d.Val()
+	e.Args = d.RemainingArgs()
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "match":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			e.MatchFiles = append(e.MatchFiles, args...)
+			continue
+		case "env":
+			var name, value string
+			if !d.Args(&name, &value) {
+				return d.ArgErr()
+			}
+			if e.Env == nil {
+				e.Env = make(map[string]string)
+			}
+			e.Env[name] = value
+		case "timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing timeout duration: %v", err)
+			}
+			e.Timeout = caddy.Duration(dur)
+		case "max_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("parsing max_size: %v", err)
+			}
+			e.MaxSize = int64(size)
+		default:
+			return d.Errf("unrecognized exec option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*ExecHandler)(nil)
+	_ MiddlewareHandler     = (*ExecHandler)(nil)
+	_ caddyfile.Unmarshaler = (*ExecHandler)(nil)
+)
@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestExecHandler(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "plain.txt"), []byte("plain"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, &Server{Root: root},
+		&ExecHandler{
+			MatchFiles: []string{"*.ipxe"},
+			Command:    "sh",
+			Args:       []string{"-c", `printf '%s %s %s %s' "$TFTP_FILENAME" "$TFTP_CLIENT_IP" "$TFTP_MODE" "$GREETING"`},
+			Env:        map[string]string{"GREETING": "hello {tftp.request.file}"},
+		},
+		&ExecHandler{MatchFiles: []string{"fail"}, Command: "sh", Args: []string{"-c", "echo broken >&2; exit 3"}},
+		&ExecHandler{MatchFiles: []string{"large"}, Command: "sh", Args: []string{"-c", "head -c 5000 /dev/zero"}, MaxSize: 1000},
+		&ExecHandler{MatchFiles: []string{"slow"}, Command: "sh", Args: []string{"-c", "exec sleep 5"}, Timeout: caddy.Duration(200 * time.Millisecond)},
+		&FileServer{},
+	)
+
+	if got, err := download(t, addr, "boot/host.ipxe"); err != nil || got != "boot/host.ipxe 127.0.0.1 octet hello host.ipxe" {
+		t.Errorf("host.ipxe: got %q, %v", got, err)
+	}
+	// a command that fails before writing anything fails the request
+	_, err := download(t, addr, "fail")
+	wantCode(t, err, errCodeNotDefined)
+	if !strings.Contains(err.Error(), "broken") {
+		t.Errorf("fail: got %v, want the stderr of the command", err)
+	}
+	if _, err := download(t, addr, "large"); err == nil {
+		t.Error("large: succeeded beyond max_size")
+	}
+	start := time.Now()
+	_, err = download(t, addr, "slow")
+	wantCode(t, err, errCodeNotDefined)
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("slow: took %v, want the command killed after the timeout", d)
+	}
+
+	// other names and uploads are passed on to the next handler
+	if got, err := download(t, addr, "plain.txt"); err != nil || got != "plain" {
+		t.Errorf("plain.txt: got %q, %v", got, err)
+	}
+	if err := upload(t, addr, "up.ipxe", "uploaded"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "up.ipxe")); err != nil || string(data) != "uploaded" {
+		t.Errorf("up.ipxe: %q, %v", data, err)
+	}
+}
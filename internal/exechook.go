@@ -0,0 +1,114 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(ExecHook{})
+}
+
+// ExecHook runs a command after an upload, e.g. to validate or
+// archive the configuration backup a network device just pushed.
+type ExecHook struct {
+	// The command to run. Placeholders are supported.
+	Command string `json:"command,omitempty"`
+
+	// The arguments of the command. Placeholders are supported.
+	// Default is the path of the uploaded file.
+	Args []string `json:"args,omitempty"`
+
+	// The maximum time the command may run before it is killed.
+	// Default is 1 minute.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	log *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (ExecHook) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.hooks.exec",
+		New: func() caddy.Module { return new(ExecHook) },
+	}
+}
+
+// Provision validates the configuration.
+func (e *ExecHook) Provision(ctx caddy.Context) error {
+	e.log = ctx.Logger()
+	if e.Command == "" {
+		return fmt.Errorf("command is required")
+	}
+	if e.Timeout <= 0 {
+		e.Timeout = caddy.Duration(time.Minute)
+	}
+	return nil
+}
+
+// RunHook implements UploadHook.
+func (e *ExecHook) RunHook(r *Request, path string) error {
+	repl := r.Replacer()
+	args := []string{path}
+	if len(e.Args) > 0 {
+		args = make([]string, len(e.Args))
+		for i, arg := range e.Args {
+			args[i] = repl.ReplaceAll(arg, "")
+		}
+	}
+	command := repl.ReplaceAll(e.Command, "")
+	ctx, cancel := context.WithTimeout(r.Context(), time.Duration(e.Timeout))
+	defer cancel()
+	cmd := exec.CommandContext(ctx, command, args...)
+	e.log.Debug("running command", zap.String("transfer_id", r.ID), zap.String("command", command), zap.Strings("args", args))
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("running %s: %v: %s", command, err, out)
+	}
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens.
+//
+//	exec <command> [<args...>] {
+//	    timeout <duration>
+//	}
+func (e *ExecHook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume hook name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	e.Command = d.Val()
+	e.Args = d.RemainingArgs()
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing timeout duration: %v", err)
+			}
+			e.Timeout = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized exec option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*ExecHook)(nil)
+	_ UploadHook            = (*ExecHook)(nil)
+	_ caddyfile.Unmarshaler = (*ExecHook)(nil)
+)
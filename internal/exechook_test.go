@@ -0,0 +1,45 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestExecHookRunsAfterUpload(t *testing.T) {
+	root := t.TempDir()
+	marker := filepath.Join(t.TempDir(), "marker")
+	fsrv := &FileServer{}
+	hook := &ExecHook{Command: "sh", Args: []string{"-c", `sleep 1.5; cat "$1" > "$2"`, "sh", "{tftp.upload.path}", marker}}
+	if err := hook.Provision(caddy.Context{}); err != nil {
+		t.Fatal(err)
+	}
+	fsrv.hooks = []UploadHook{hook}
+	// the hook outlives the transfer and its limits
+	addr := startServer(t, &Server{Root: root, MaxTransferDuration: caddy.Duration(500 * time.Millisecond)}, fsrv)
+
+	start := time.Now()
+	if err := upload(t, addr, "switch1.cfg", "hostname switch1"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("upload took %v, the hook held up the final ACK", elapsed)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, err := os.ReadFile(marker)
+		if err == nil {
+			if string(data) != "hostname switch1" {
+				t.Errorf("hook saw %q", data)
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("hook did not run")
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,869 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/dustin/go-humanize"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(FileServer{})
+}
+
+// FileServer serves downloads from and stores uploads in a directory.
+// It is the default handler of a server without explicit handlers.
+type FileServer struct {
+	// The name of the file system to serve from, as registered
+	// in the global filesystems option. By default, Caddy uses
+	// the local disk file system.
+	// Uploads are only supported by the local disk file system.
+	FileSystem string `json:"fs,omitempty"`
+
+	// The path to the root of the site. Placeholders are supported,
+	// e.g. /srv/tftp/{tftp.request.remote.host} gives every client a
+	// directory of its own, which is created on its first upload.
+	// Default is the root of the server, or the top level of the
+	// file system if fs is set.
+	Root string `json:"root,omitempty"`
+
+	// Directories to search in order for downloads not found in the
+	// root, e.g. a read-only shared image store below a site-specific
+	// root; the first one with the requested file serves it. Uploads
+	// always go to the root. Placeholders are supported. Only supported
+	// by the local disk file system.
+	FallbackRoots []string `json:"fallback_roots,omitempty"`
+
+	// File names to try in order for downloads; the first one that exists
+	// is sent. {path} is the requested file name, and placeholders are
+	// supported, e.g. ["{path}", "{path}.default", "fallback.cfg"].
+	// Default is the requested file name only.
+	TryFiles []string `json:"try_files,omitempty"`
+
+	// A reserved file name, e.g. "dir.txt" or "manifest.txt", that reads a
+	// listing of the directory it is requested in instead of a file:
+	// a line per entry with the name and size of files, separated by a
+	// tab, and the name of directories followed by a slash. Hidden files
+	// and names starting with a dot are left out. Default is none.
+	Manifest string `json:"manifest,omitempty"`
+
+	// Precompressed formats to fall back to for downloads, tried in
+	// order: if the requested file does not exist but a compressed copy
+	// of it does, e.g. file.bin.gz, the copy is decompressed on the fly.
+	// Supported formats are gzip (.gz) and zstd (.zst). Default is none.
+	Precompressed []string `json:"precompressed,omitempty"`
+
+	// Stores the SHA-256 checksum of every upload in a sidecar
+	// file named after the upload with .sha256 appended.
+	WriteChecksums bool `json:"write_checksums,omitempty"`
+
+	// Verifies downloads against their .sha256 sidecar file, if any,
+	// before serving them. Files that do not match are not served.
+	VerifyChecksums bool `json:"verify_checksums,omitempty"`
+
+	// Keeps recently served files in memory, so repeated downloads of
+	// the same kernel or initrd do not read it from disk every time.
+	// Cached files are reloaded once their modification time changes.
+	Cache *FileCache `json:"cache,omitempty"`
+
+	// Creates missing parent directories of uploads, e.g. backups/site-12
+	// for an upload to backups/site-12/router-3.cfg, instead of rejecting
+	// the upload.
+	CreateDirs bool `json:"create_dirs,omitempty"`
+
+	// The permissions of directories created for uploads, in octal.
+	// Default is 0755.
+	DirMode string `json:"dir_mode,omitempty"`
+
+	// Memory-maps downloads of files of at least this size in bytes from
+	// the local disk instead of reading them through buffers, which saves
+	// a copy of every block for large images. Files must not be truncated
+	// while they are served; uploads replace files, so they are safe.
+	// Only supported on Unix platforms. Default is 0, which disables it.
+	MmapMinSize int64 `json:"mmap_min_size,omitempty"`
+
+	// Hooks to run after an upload was stored, in order.
+	HooksRaw []json.RawMessage `json:"hooks,omitempty" caddy:"namespace=tftp.hooks inline_key=hook"`
+
+	hooks   []UploadHook
+	dirMode os.FileMode
+	sizes   *sizeCache
+	cache   *fileCache
+	files   *sharedFiles
+	fsmap   caddy.FileSystems
+	log     *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (FileServer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.file_server",
+		New: func() caddy.Module { return new(FileServer) },
+	}
+}
+
+// Provision sets up the file server.
+func (fsrv *FileServer) Provision(ctx caddy.Context) error {
+	fsrv.log = ctx.Logger()
+	fsrv.fsmap = ctx.Filesystems()
+	fsrv.sizes = new(sizeCache)
+	fsrv.files = newSharedFiles()
+	if fsrv.Cache != nil {
+		fsrv.cache = newFileCache(fsrv.Cache)
+	}
+	if fsrv.MmapMinSize > 0 && !canMmap {
+		return fmt.Errorf("mmap_min_size is not supported on this platform")
+	}
+	if strings.Contains(fsrv.Manifest, "/") {
+		return fmt.Errorf("manifest must be a file name without a slash")
+	}
+	fsrv.dirMode = 0755
+	if fsrv.DirMode != "" {
+		mode, err := strconv.ParseUint(fsrv.DirMode, 8, 32)
+		if err != nil || mode > 0777 {
+			return fmt.Errorf("invalid dir_mode '%s'", fsrv.DirMode)
+		}
+		fsrv.dirMode = os.FileMode(mode)
+	}
+	for _, format := range fsrv.Precompressed {
+		if _, ok := precompressedExts[format]; !ok {
+			return fmt.Errorf("unsupported precompressed format '%s'", format)
+		}
+	}
+	if fsrv.HooksRaw != nil {
+		mods, err := ctx.LoadModule(fsrv, "HooksRaw")
+		if err != nil {
+			return fmt.Errorf("loading hook modules: %v", err)
+		}
+		for _, mod := range mods.([]any) {
+			fsrv.hooks = append(fsrv.hooks, mod.(UploadHook))
+		}
+	}
+	if fsrv.FileSystem != "" {
+		if len(fsrv.FallbackRoots) > 0 {
+			return fmt.Errorf("fallback_roots is not supported with fs")
+		}
+		if fsrv.Root == "" {
+			fsrv.Root = "."
+		}
+		return nil
+	}
+	// request placeholders in the roots are replaced per request
+	repl := caddy.NewReplacer()
+	if fsrv.Root != "" {
+		root, err := filepath.Abs(repl.ReplaceKnown(fsrv.Root, ""))
+		if err != nil {
+			return err
+		}
+		fsrv.Root = root
+	}
+	for i, fallback := range fsrv.FallbackRoots {
+		root, err := filepath.Abs(repl.ReplaceKnown(fallback, ""))
+		if err != nil {
+			return err
+		}
+		fsrv.FallbackRoots[i] = root
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (fsrv *FileServer) ServeTFTP(r *Request, _ Handler) error {
+	if fsrv.FileSystem != "" {
+		return fsrv.serveFileSystem(r)
+	}
+	root := fsrv.Root
+	if root == "" {
+		root = r.Root
+	}
+	root, scoped := fsrv.expandRoot(r, root)
+	if r.Method == MethodWrite && scoped {
+		if err := os.MkdirAll(root, fsrv.dirMode); err != nil {
+			return err
+		}
+	}
+	if r.Method != MethodWrite {
+		return fsrv.serveRoots(r, root)
+	}
+	// all access goes through rt, which keeps file names and symlinks
+	// from escaping the root
+	rt, err := os.OpenRoot(root)
+	if err != nil {
+		return err
+	}
+	defer rt.Close()
+
+	name, err := fsrv.fsName(".", r.Filename)
+	if err != nil {
+		return err
+	}
+	if r.foldCase {
+		name = foldPath(rt.FS(), name)
+	}
+	if fsrv.CreateDirs {
+		// directories are created within rt, so they cannot escape the
+		// root; symlinks among them are still checked below
+		if err := rt.MkdirAll(filepath.FromSlash(path.Dir(name)), fsrv.dirMode); err != nil {
+			return err
+		}
+	}
+	if r.Symlinks == "deny" {
+		if err := checkNoSymlinks(rt, path.Dir(name)); err != nil {
+			return err
+		}
+	}
+	name = filepath.FromSlash(name)
+	if err := fsrv.receiveFile(r, rt, name); err != nil {
+		return err
+	}
+	if fsrv.WriteChecksums {
+		fsrv.storeChecksum(r, rt, name)
+	}
+	fsrv.runHooks(r, filepath.Join(root, name))
+	return nil
+}
+
+// serveRoots serves a download from root or, if it does not have the
+// file, from the first of the fallback roots that does.
+func (fsrv *FileServer) serveRoots(r *Request, root string) error {
+	err := fsrv.serveRoot(r, root)
+	for _, fallback := range fsrv.FallbackRoots {
+		if !errors.Is(err, fs.ErrNotExist) || r.started {
+			break
+		}
+		fallback, _ = fsrv.expandRoot(r, fallback)
+		err = fsrv.serveRoot(r, fallback)
+	}
+	return err
+}
+
+// serveRoot serves a download from the directory root.
+func (fsrv *FileServer) serveRoot(r *Request, root string) error {
+	// all access goes through rt, which keeps file names and symlinks
+	// from escaping the root
+	rt, err := os.OpenRoot(root)
+	if err != nil {
+		return err
+	}
+	defer rt.Close()
+	return fsrv.serveFS(r, localFS(r, rt, root), root, ".")
+}
+
+// expandRoot replaces the placeholders in root, reporting whether
+// there were any.
+func (fsrv *FileServer) expandRoot(r *Request, root string) (string, bool) {
+	expanded := r.Replacer().ReplaceAll(root, "")
+	if expanded == root {
+		return root, false
+	}
+	if fsrv.FileSystem != "" {
+		return path.Clean(expanded), true
+	}
+	return filepath.Clean(expanded), true
+}
+
+// receiveFile stores an upload at name in rt. The upload is written to a
+// temporary file next to name first, which is renamed to name once it is
+// complete, so an interrupted upload never leaves a truncated file behind.
+// An existing file at name is handled according to the overwrite policy.
+func (fsrv *FileServer) receiveFile(r *Request, rt *os.Root, name string) (err error) {
+	_, err = rt.Lstat(name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	replace := r.Overwrite == "allow" || r.Overwrite == "version"
+	if exists && !replace {
+		return fs.ErrExist
+	}
+	tmpName, tmp, err := createTemp(rt, name)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = tmp.Close()
+		if err != nil {
+			_ = rt.Remove(tmpName)
+		}
+	}()
+	if _, err := r.WriteTo(tmp); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if !replace {
+		// another upload of the same name may have been stored since the
+		// check above, which a rename would silently replace
+		if err := createFrom(rt, tmpName, name); err != nil {
+			return err
+		}
+		_ = rt.Remove(tmpName)
+		return nil
+	}
+	if exists && r.Overwrite == "version" {
+		// a previous copy may have been removed since the check above
+		version := name + "." + time.Now().UTC().Format("20060102T150405.000Z")
+		if err := rt.Rename(name, version); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+	return rt.Rename(tmpName, name)
+}
+
+// createFrom stores the file tmpName in rt as name unless name exists,
+// failing with fs.ErrExist then. The file is linked to name, so name
+// appears complete; on file systems without hard links it is copied to
+// a file created exclusively instead.
+func createFrom(rt *os.Root, tmpName, name string) error {
+	err := rt.Link(tmpName, name)
+	if err == nil || errors.Is(err, fs.ErrExist) {
+		return err
+	}
+	src, err := rt.Open(tmpName)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+	dst, err := rt.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		_ = dst.Close()
+		_ = rt.Remove(name)
+		return err
+	}
+	if err := dst.Close(); err != nil {
+		_ = rt.Remove(name)
+		return err
+	}
+	return nil
+}
+
+// createTemp creates a new hidden file next to name in rt.
+func createTemp(rt *os.Root, name string) (string, *os.File, error) {
+	dir, base := filepath.Split(name)
+	for {
+		tmpName := dir + "." + base + "." + strconv.FormatUint(rand.Uint64(), 36) + ".tmp"
+		file, err := rt.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		return tmpName, file, err
+	}
+}
+
+// storeChecksum writes the checksum sidecar of the upload stored at name
+// in rt and makes the checksum available as {tftp.upload.sha256}.
+func (fsrv *FileServer) storeChecksum(r *Request, rt *os.Root, name string) {
+	sum, err := writeChecksum(rt, name)
+	if err != nil {
+		fsrv.log.Error("writing checksum failed", zap.String("transfer_id", r.ID), zap.String("path", name), zap.Error(err))
+		return
+	}
+	r.Replacer().Set("tftp.upload.sha256", sum)
+	fsrv.log.Info("upload stored", zap.String("transfer_id", r.ID), zap.String("path", name), zap.String("sha256", sum))
+}
+
+// verify checks the file name against its checksum sidecar, if any.
+func (fsrv *FileServer) verify(open func(string) (fs.File, error), name string) error {
+	err := verifyChecksum(open, name)
+	if errors.Is(err, errChecksumMismatch) {
+		fsrv.log.Error("checksum mismatch", zap.String("path", name))
+	}
+	return err
+}
+
+// runHooks runs the upload hooks for the upload stored at p once the
+// upload is done.
+func (fsrv *FileServer) runHooks(r *Request, p string) {
+	if len(fsrv.hooks) == 0 {
+		return
+	}
+	repl := r.Replacer()
+	repl.Set("tftp.upload.path", p)
+	repl.Set("tftp.upload.size", r.Bytes())
+	r.afterUpload(func() {
+		fsrv.callHooks(r, p)
+	})
+}
+
+// callHooks calls the upload hooks in order for the upload stored at p.
+func (fsrv *FileServer) callHooks(r *Request, p string) {
+	for _, hook := range fsrv.hooks {
+		if err := hook.RunHook(r, p); err != nil {
+			fsrv.log.Error(
+				"upload hook failed",
+				zap.String("transfer_id", r.ID),
+				zap.String("hook", string(hook.(caddy.Module).CaddyModule().ID)),
+				zap.String("path", p),
+				zap.Error(err),
+			)
+		}
+	}
+}
+
+// serveFileSystem serves a read request from a named file system.
+func (fsrv *FileServer) serveFileSystem(r *Request) error {
+	if r.Method == MethodWrite {
+		return fmt.Errorf("file system %s does not support uploads", fsrv.FileSystem)
+	}
+	fileSystem, ok := fsrv.fsmap.Get(fsrv.FileSystem)
+	if !ok {
+		return fmt.Errorf("file system %s not found", fsrv.FileSystem)
+	}
+	root, _ := fsrv.expandRoot(r, fsrv.Root)
+	return fsrv.serveFS(r, fileSystem, fsrv.FileSystem, root)
+}
+
+// serveFS serves a read request from the files below root in fsys;
+// id identifies fsys in the decompressed size cache.
+func (fsrv *FileServer) serveFS(r *Request, fsys fs.FS, id, root string) error {
+	if dir, file := path.Split(path.Clean("/" + r.Filename)); fsrv.Manifest != "" && file == fsrv.Manifest {
+		name, err := fsrv.fsName(root, dir)
+		if err != nil {
+			return err
+		}
+		if r.foldCase {
+			name = foldPath(fsys, name)
+		}
+		return fsrv.sendManifest(r, fsys, name, dir)
+	}
+	name, format, err := fsrv.resolve(r, func(filename string) (string, error) {
+		name, err := fsrv.fsName(root, filename)
+		if err == nil && r.foldCase {
+			name = foldPath(fsys, name)
+		}
+		return name, err
+	}, func(name string) (fs.FileInfo, error) {
+		return fs.Stat(fsys, name)
+	})
+	if err != nil {
+		return err
+	}
+	if fsrv.VerifyChecksums {
+		if err := fsrv.verify(fsys.Open, name); err != nil {
+			return err
+		}
+	}
+	return fsrv.sendFile(r, fsys, id, name, format)
+}
+
+// sendManifest sends the listing of the directory name in fsys,
+// which the client requested as dir.
+func (fsrv *FileServer) sendManifest(r *Request, fsys fs.FS, name, dir string) error {
+	entries, err := fs.ReadDir(fsys, name)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), ".") || matchPathFold(r.hide, path.Join(dir, entry.Name()), r.foldCase) {
+			continue
+		}
+		// follows symlinks the way downloads would
+		info, err := fs.Stat(fsys, path.Join(name, entry.Name()))
+		if err != nil {
+			continue
+		}
+		if info.IsDir() {
+			fmt.Fprintf(&buf, "%s/\n", entry.Name())
+		} else {
+			fmt.Fprintf(&buf, "%s\t%d\n", entry.Name(), info.Size())
+		}
+	}
+	r.SetSize(int64(buf.Len()))
+	_, err = r.ReadFrom(&buf)
+	return err
+}
+
+// fsName returns the name of filename below root in a file system.
+func (fsrv *FileServer) fsName(root, filename string) (string, error) {
+	name := strings.TrimPrefix(path.Join(root, path.Clean("/"+filepath.ToSlash(filename))), "/")
+	if name == "" {
+		name = "."
+	}
+	fsrv.log.Debug(
+		"sanitized path join",
+		zap.String("fs", fsrv.FileSystem),
+		zap.String("root", root),
+		zap.String("filename", filename),
+		zap.String("result", name),
+	)
+	if !fs.ValidPath(name) {
+		return "", errors.New("unsafe or invalid filename specified")
+	}
+	return name, nil
+}
+
+// foldPath returns the path in fsys that matches the slash-separated
+// name case-insensitively, preferring exact matches element by element.
+// Elements without a match are kept as they are.
+func foldPath(fsys fs.FS, name string) string {
+	resolved := "."
+	for _, elem := range strings.Split(name, "/") {
+		next := path.Join(resolved, elem)
+		if _, err := fs.Stat(fsys, next); errors.Is(err, fs.ErrNotExist) {
+			entries, _ := fs.ReadDir(fsys, resolved)
+			for _, entry := range entries {
+				if strings.EqualFold(entry.Name(), elem) {
+					next = path.Join(resolved, entry.Name())
+					break
+				}
+			}
+		}
+		resolved = next
+	}
+	return resolved
+}
+
+// resolve returns the path of the file to send for r, along with its
+// precompressed format if it is a compressed copy of the requested file.
+// If try_files is set, it returns the first candidate that exists; join
+// maps a file name to its path and stat describes the file at a path.
+func (fsrv *FileServer) resolve(r *Request, join func(string) (string, error), stat func(string) (fs.FileInfo, error)) (string, string, error) {
+	if len(fsrv.TryFiles) == 0 {
+		p, err := join(r.Filename)
+		if err != nil || len(fsrv.Precompressed) == 0 {
+			return p, "", err
+		}
+		if found, format, ok := fsrv.find(p, stat); ok {
+			return found, format, nil
+		}
+		// opening p reports why it cannot be sent
+		return p, "", nil
+	}
+	repl := r.Replacer()
+	for _, candidate := range fsrv.TryFiles {
+		filename := repl.ReplaceAll(strings.ReplaceAll(candidate, "{path}", r.Filename), "")
+		p, err := join(filename)
+		if err != nil {
+			continue
+		}
+		if found, format, ok := fsrv.find(p, stat); ok {
+			fsrv.log.Debug("resolved file", zap.String("transfer_id", r.ID), zap.String("filename", r.Filename), zap.String("result", found))
+			return found, format, nil
+		}
+	}
+	return "", "", fs.ErrNotExist
+}
+
+// find returns p if it is a file, or else the first precompressed copy
+// of it that exists along with its format.
+func (fsrv *FileServer) find(p string, stat func(string) (fs.FileInfo, error)) (string, string, bool) {
+	if info, err := stat(p); err == nil && !info.IsDir() {
+		return p, "", true
+	}
+	for _, format := range fsrv.Precompressed {
+		compressed := p + precompressedExts[format]
+		if info, err := stat(compressed); err == nil && !info.IsDir() {
+			return compressed, format, true
+		}
+	}
+	return "", "", false
+}
+
+// sendFile sends the file name in fsys to the client,
+// decompressing it if format is set.
+func (fsrv *FileServer) sendFile(r *Request, fsys fs.FS, id, name, format string) error {
+	if fsrv.cache != nil {
+		if data, ok := fsrv.cached(fsys, id, name, format); ok {
+			r.SetSize(int64(len(data)))
+			_, err := r.ReadFrom(bytes.NewReader(data))
+			return err
+		}
+	}
+	if format == "" && fsrv.FileSystem == "" {
+		return fsrv.sendShared(r, fsys, id, name)
+	}
+	file, err := fsys.Open(name)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fs.ErrNotExist
+	}
+	if format == "" {
+		r.SetSize(info.Size())
+		if osFile, ok := file.(*os.File); ok && fsrv.MmapMinSize > 0 && info.Size() >= fsrv.MmapMinSize {
+			return fsrv.sendMapped(r, osFile, info.Size())
+		}
+		_, err = r.ReadFrom(file)
+		return err
+	}
+	size, err := fsrv.sizes.decompressedSize(fsys, id, name, format, info)
+	if err != nil {
+		return fmt.Errorf("decompressing %s: %v", name, err)
+	}
+	dr, err := newDecompressor(format, file)
+	if err != nil {
+		return err
+	}
+	defer dr.Close()
+	r.SetSize(size)
+	_, err = r.ReadFrom(dr)
+	return err
+}
+
+// sendMapped sends the first size bytes of file from a memory mapping,
+// falling back to reading it if it cannot be mapped.
+func (fsrv *FileServer) sendMapped(r *Request, file *os.File, size int64) error {
+	data, err := mmapFile(file, size)
+	if err != nil {
+		fsrv.log.Debug("mapping file failed", zap.String("transfer_id", r.ID), zap.String("path", file.Name()), zap.Error(err))
+		_, err = r.ReadFrom(file)
+		return err
+	}
+	defer func() { _ = munmap(data) }()
+	_, err = r.ReadFrom(bytes.NewReader(data))
+	return err
+}
+
+// sendShared sends the file name from the local root identified by id,
+// sharing the open file, and its memory mapping if it is large enough
+// to be mapped, with the other downloads of it at the same time.
+func (fsrv *FileServer) sendShared(r *Request, fsys fs.FS, id, name string) error {
+	info, err := fs.Stat(fsys, name)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fs.ErrNotExist
+	}
+	f, err := fsrv.files.acquire(id+":"+name, info, func() (*os.File, fs.FileInfo, []byte, error) {
+		return fsrv.openShared(r, fsys, name)
+	})
+	if err != nil {
+		return err
+	}
+	defer fsrv.files.release(f)
+	size := f.info.Size()
+	r.SetSize(size)
+	if f.data != nil {
+		_, err = r.ReadFrom(bytes.NewReader(f.data))
+		return err
+	}
+	_, err = r.ReadFrom(io.NewSectionReader(f.file, 0, size))
+	return err
+}
+
+// openShared opens the file name in fsys to be shared between downloads,
+// memory-mapping it if it is at least mmap_min_size.
+func (fsrv *FileServer) openShared(r *Request, fsys fs.FS, name string) (*os.File, fs.FileInfo, []byte, error) {
+	file, err := fsys.Open(name)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	info, err := file.Stat()
+	if err == nil && info.IsDir() {
+		err = fs.ErrNotExist
+	}
+	osFile, ok := file.(*os.File)
+	if err == nil && !ok {
+		err = fmt.Errorf("unsupported file type %T", file)
+	}
+	if err != nil {
+		_ = file.Close()
+		return nil, nil, nil, err
+	}
+	if fsrv.MmapMinSize == 0 || info.Size() < fsrv.MmapMinSize {
+		return osFile, info, nil, nil
+	}
+	data, err := mmapFile(osFile, info.Size())
+	if err != nil {
+		// reading the file works just as well
+		fsrv.log.Debug("mapping file failed", zap.String("transfer_id", r.ID), zap.String("path", osFile.Name()), zap.Error(err))
+		return osFile, info, nil, nil
+	}
+	return osFile, info, data, nil
+}
+
+// cached returns the contents of the file name in fsys, identified by id,
+// from the cache, loading them if the file is small enough to be cached.
+func (fsrv *FileServer) cached(fsys fs.FS, id, name, format string) ([]byte, bool) {
+	info, err := fs.Stat(fsys, name)
+	if err != nil || info.IsDir() {
+		return nil, false
+	}
+	size := info.Size()
+	if format != "" {
+		size, err = fsrv.sizes.decompressedSize(fsys, id, name, format, info)
+		if err != nil {
+			return nil, false
+		}
+	}
+	if size > fsrv.cache.maxFileSize {
+		return nil, false
+	}
+	data, err := fsrv.cache.get(id+":"+name, info, func() ([]byte, error) {
+		file, err := fsys.Open(name)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		var rd io.Reader = file
+		if format != "" {
+			dr, err := newDecompressor(format, file)
+			if err != nil {
+				return nil, err
+			}
+			defer dr.Close()
+			rd = dr
+		}
+		return io.ReadAll(rd)
+	})
+	if err != nil {
+		fsrv.log.Error("caching file failed", zap.String("path", name), zap.Error(err))
+		return nil, false
+	}
+	return data, true
+}
+
+// UnmarshalCaddyfile sets up the file server from Caddyfile tokens.
+//
+//	file_server [<root> [<fallback_roots...>]] {
+//	    fs   <name>
+//	    root <path> [<fallback_roots...>]
+//	    try_files <files...>
+//	    manifest  <name>
+//	    precompressed [<formats...>]
+//	    write_checksums
+//	    verify_checksums
+//	    create_dirs [<mode>]
+//	    mmap_min_size <size>
+//	    cache {
+//	        max_size      <size>
+//	        max_file_size <size>
+//	    }
+//	    hook <name> [<args...>] {
+//	        ...
+//	    }
+//	}
+func (fsrv *FileServer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if d.NextArg() {
+		fsrv.Root = d.Val()
+		fsrv.FallbackRoots = d.RemainingArgs()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "fs":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fsrv.FileSystem = d.Val()
+		case "root":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fsrv.Root = d.Val()
+			fsrv.FallbackRoots = d.RemainingArgs()
+			continue
+		case "manifest":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			fsrv.Manifest = d.Val()
+		case "try_files":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			fsrv.TryFiles = append(fsrv.TryFiles, args...)
+			continue
+		case "precompressed":
+			fsrv.Precompressed = d.RemainingArgs()
+			if len(fsrv.Precompressed) == 0 {
+				fsrv.Precompressed = []string{"zstd", "gzip"}
+			}
+			continue
+		case "write_checksums":
+			fsrv.WriteChecksums = true
+		case "verify_checksums":
+			fsrv.VerifyChecksums = true
+		case "create_dirs":
+			fsrv.CreateDirs = true
+			if d.NextArg() {
+				fsrv.DirMode = d.Val()
+			}
+		case "mmap_min_size":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			size, err := humanize.ParseBytes(d.Val())
+			if err != nil {
+				return d.Errf("parsing mmap_min_size: %v", err)
+			}
+			fsrv.MmapMinSize = int64(size)
+		case "cache":
+			fsrv.Cache = new(FileCache)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				switch d.Val() {
+				case "max_size", "max_file_size":
+					name := d.Val()
+					if !d.NextArg() {
+						return d.ArgErr()
+					}
+					size, err := humanize.ParseBytes(d.Val())
+					if err != nil {
+						return d.Errf("parsing %s: %v", name, err)
+					}
+					if name == "max_size" {
+						fsrv.Cache.MaxSize = int64(size)
+					} else {
+						fsrv.Cache.MaxFileSize = int64(size)
+					}
+				default:
+					return d.Errf("unrecognized cache option '%s'", d.Val())
+				}
+			}
+			continue
+		case "hook":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			hookName := d.Val()
+			modID := "tftp.hooks." + hookName
+			unm, err := caddyfile.UnmarshalModule(d, modID)
+			if err != nil {
+				return err
+			}
+			fsrv.HooksRaw = append(fsrv.HooksRaw, caddyconfig.JSONModuleObject(unm, "hook", hookName, nil))
+			continue
+		default:
+			return d.Errf("unrecognized file_server option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*FileServer)(nil)
+	_ MiddlewareHandler     = (*FileServer)(nil)
+	_ caddyfile.Unmarshaler = (*FileServer)(nil)
+)
@@ -0,0 +1,110 @@
+package internal
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func TestFoldPath(t *testing.T) {
+	fsys := fstest.MapFS{
+		"boot/BCD":             {},
+		"Boot/bcd":             {},
+		"EFI/BOOT/bootx64.efi": {},
+		"pxelinux.cfg/default": {},
+	}
+	for _, tc := range []struct {
+		name string
+		want string
+	}{
+		{"boot/BCD", "boot/BCD"},
+		{"Boot/bcd", "Boot/bcd"},
+		{"efi/boot/BOOTX64.EFI", "EFI/BOOT/bootx64.efi"},
+		{"PXELINUX.CFG/Default", "pxelinux.cfg/default"},
+		{"missing/File", "missing/File"},
+		{"efi/Missing", "EFI/Missing"},
+	} {
+		if got := foldPath(fsys, tc.name); got != tc.want {
+			t.Errorf("foldPath(%q) = %q, want %q", tc.name, got, tc.want)
+		}
+	}
+}
+
+// TestFoldPathChecks checks that a name the file server resolves
+// case-insensitively to a hidden or restricted file is hidden or
+// restricted itself, whatever its case.
+func TestFoldPathChecks(t *testing.T) {
+	fsys := fstest.MapFS{
+		"secure/boot.cfg": {},
+		"keys/host.key":   {},
+		"public/boot.cfg": {},
+	}
+	rule, err := AccessRule{Files: []string{"secure/*"}, Allow: []string{"10.0.0.0/8"}}.provision()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &tftpServer{hide: []string{"*.key"}, rules: []accessRule{rule}, foldCase: true}
+	for _, name := range []string{
+		"secure/boot.cfg", "SECURE/boot.cfg", "Secure/Boot.CFG",
+		"keys/host.key", "KEYS/HOST.KEY", "keys/host.Key",
+		"public/boot.cfg", "PUBLIC/BOOT.CFG",
+	} {
+		resolved := foldPath(fsys, name)
+		r := &Request{Method: MethodRead, Filename: name, foldCase: true}
+		r.RemoteAddr.IP = net.ParseIP("192.0.2.1")
+		resolvedReq := &Request{Method: MethodRead, Filename: resolved}
+		resolvedReq.RemoteAddr.IP = r.RemoteAddr.IP
+
+		if s.hidden(resolved) && !s.hidden(name) {
+			t.Errorf("%q resolves to hidden %q but is not hidden", name, resolved)
+		}
+		if !s.permitted(resolvedReq) && s.permitted(r) {
+			t.Errorf("%q resolves to restricted %q but is permitted", name, resolved)
+		}
+	}
+}
+
+func TestConcurrentUploadsDeny(t *testing.T) {
+	root := t.TempDir()
+	addr := startServer(t, &Server{Root: root})
+
+	const uploads = 8
+	errs := make(chan error, uploads)
+	for i := range uploads {
+		go func() {
+			// large enough for the transfers to overlap
+			errs <- upload(t, addr, "router.cfg", strings.Repeat(strconv.Itoa(i), 64<<10))
+		}()
+	}
+	// uploads found to collide once they started fail with the generic
+	// code the library sends mid-transfer, so only the message tells
+	var stored int
+	for range uploads {
+		if err := <-errs; err == nil {
+			stored++
+		} else if !strings.Contains(err.Error(), "exists") {
+			t.Errorf("upload failed: %v", err)
+		}
+	}
+	if stored != 1 {
+		t.Errorf("%d uploads stored, want 1", stored)
+	}
+	data, err := os.ReadFile(filepath.Join(root, "router.cfg"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) != 64<<10 || strings.Count(string(data), string(data[0])) != len(data) {
+		t.Error("stored file mixes uploads")
+	}
+	entries, err := os.ReadDir(root)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("root has %d entries, want only the upload", len(entries))
+	}
+}
@@ -0,0 +1,149 @@
+package internal
+
+import (
+	"cmp"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// FileStats configures the statistics a server keeps per file, which
+// the admin API reports at /tftp/servers/<name>/stats. They are kept in
+// memory only, so they start over on restarts and config reloads.
+type FileStats struct {
+	// The maximum number of files to keep statistics of; the statistics
+	// of the file transferred least recently are dropped to make room
+	// for another one. Default is 1000.
+	MaxFiles int `json:"max_files,omitempty"`
+
+	// The period after which the statistics start over, e.g. 24h.
+	// Default is never.
+	Rollover caddy.Duration `json:"rollover,omitempty"`
+}
+
+// fileStatKey identifies the statistics of a file.
+type fileStatKey struct {
+	method   string
+	filename string
+}
+
+// fileStat is the statistics of a file.
+type fileStat struct {
+	Filename    string    `json:"filename"`
+	Method      string    `json:"method"`
+	Transfers   int64     `json:"transfers"`
+	Failed      int64     `json:"failed"`
+	Bytes       int64     `json:"bytes"`
+	AvgDuration string    `json:"avg_duration"`
+	Last        time.Time `json:"last"`
+
+	duration time.Duration
+}
+
+// fileStatsReport describes the statistics of a server in the admin API.
+type fileStatsReport struct {
+	Since time.Time  `json:"since"`
+	Files []fileStat `json:"files"`
+}
+
+// fileStats keeps the statistics of the files of a server.
+type fileStats struct {
+	maxFiles int
+	rollover time.Duration
+
+	mu    sync.Mutex
+	since time.Time
+	files map[fileStatKey]*fileStat
+}
+
+func newFileStats(cfg *FileStats) *fileStats {
+	maxFiles := cfg.MaxFiles
+	if maxFiles <= 0 {
+		maxFiles = 1000
+	}
+	return &fileStats{
+		maxFiles: maxFiles,
+		rollover: time.Duration(cfg.Rollover),
+		since:    time.Now(),
+		files:    make(map[fileStatKey]*fileStat),
+	}
+}
+
+// record counts the finished request r for filename, which took d
+// and failed if err is not nil.
+func (fst *fileStats) record(r *Request, filename string, d time.Duration, err error) {
+	fst.mu.Lock()
+	defer fst.mu.Unlock()
+	now := time.Now()
+	fst.roll(now)
+	key := fileStatKey{method: r.Method, filename: filename}
+	st, ok := fst.files[key]
+	if !ok {
+		if len(fst.files) >= fst.maxFiles {
+			fst.evict()
+		}
+		st = &fileStat{Filename: filename, Method: r.Method}
+		fst.files[key] = st
+	}
+	st.Transfers++
+	if err != nil {
+		st.Failed++
+	}
+	st.Bytes += r.Bytes()
+	st.duration += d
+	st.Last = now
+}
+
+// evict drops the statistics of the file transferred least recently.
+func (fst *fileStats) evict() {
+	var oldest fileStatKey
+	var last time.Time
+	for key, st := range fst.files {
+		if last.IsZero() || st.Last.Before(last) {
+			oldest, last = key, st.Last
+		}
+	}
+	delete(fst.files, oldest)
+}
+
+// roll starts over once the rollover period has passed.
+func (fst *fileStats) roll(now time.Time) {
+	if fst.rollover > 0 && now.Sub(fst.since) >= fst.rollover {
+		fst.reset(now)
+	}
+}
+
+func (fst *fileStats) reset(now time.Time) {
+	fst.since = now
+	clear(fst.files)
+}
+
+// report returns the statistics, the most transferred files first.
+func (fst *fileStats) report() fileStatsReport {
+	fst.mu.Lock()
+	defer fst.mu.Unlock()
+	fst.roll(time.Now())
+	report := fileStatsReport{Since: fst.since, Files: make([]fileStat, 0, len(fst.files))}
+	for _, st := range fst.files {
+		entry := *st
+		entry.AvgDuration = (st.duration / time.Duration(st.Transfers)).String()
+		report.Files = append(report.Files, entry)
+	}
+	slices.SortFunc(report.Files, func(a, b fileStat) int {
+		return cmp.Or(
+			cmp.Compare(b.Transfers, a.Transfers),
+			cmp.Compare(a.Filename, b.Filename),
+			cmp.Compare(a.Method, b.Method),
+		)
+	})
+	return report
+}
+
+// restart starts over right away.
+func (fst *fileStats) restart() {
+	fst.mu.Lock()
+	defer fst.mu.Unlock()
+	fst.reset(time.Now())
+}
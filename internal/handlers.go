@@ -0,0 +1,525 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net"
+	"path"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/google/uuid"
+	"github.com/pin/tftp/v3"
+	"github.com/pin/tftp/v3/netascii"
+	"go.uber.org/zap"
+	"golang.org/x/time/rate"
+)
+
+// Request methods.
+const (
+	MethodRead  = "RRQ"
+	MethodWrite = "WRQ"
+)
+
+// Request is a single TFTP read or write request as it passes
+// through the handler pipeline of a server.
+type Request struct {
+	// A unique ID of the transfer, which its log lines
+	// and events include as transfer_id.
+	ID string
+
+	// Either MethodRead or MethodWrite.
+	Method string
+
+	// The transfer mode: octet or netascii.
+	Mode string
+
+	// The file name requested by the client.
+	// Handlers may rewrite it for handlers further down the pipeline.
+	Filename string
+
+	// The root of the server, used by handlers that serve files
+	// without a root of their own.
+	Root string
+
+	// The overwrite policy of the server for uploads to existing files:
+	// deny, allow or version.
+	Overwrite string
+
+	// The symlinks policy of the server: follow, deny or deny_escape.
+	Symlinks string
+
+	// The address of the client.
+	RemoteAddr net.UDPAddr
+
+	// The IP address the request was received on, nil if unknown.
+	LocalIP net.IP
+
+	server   string
+	hide     []string // the hide patterns of the server
+	foldCase bool     // resolve file names case-insensitively
+	ctx      context.Context
+	rf       io.ReaderFrom
+	wt       io.WriterTo
+	n        int64
+	maxBytes int64
+	size     int64 // announced with SetSize, -1 if unknown
+	limiters []*rate.Limiter
+	started  bool
+	netascii bool // translate line endings, as the tftp library does not
+
+	// the TFTP error code sent to the client, if the request failed
+	errorCode int
+
+	// the options requested by the client
+	requested map[string]string
+
+	// recorded by the transfer hook of the server
+	options       map[string]string
+	retransmits   int
+	statsRecorded bool
+
+	// logs the packets of the transfer if debug_packets applies, else nil
+	packetLog *zap.Logger
+
+	idle        *time.Timer
+	idleTimeout time.Duration
+
+	// run in the background once the upload is done, see afterUpload
+	after []func()
+
+	// checks a new file name like the server checked the requested one,
+	// nil outside of a server
+	checkName func(*Request) error
+}
+
+func newReadRequest(ctx context.Context, server, filename, root string, rf io.ReaderFrom) *Request {
+	r := &Request{ID: uuid.NewString(), Method: MethodRead, Mode: transferMode(rf), Filename: filename, Root: root, server: server, rf: rf, size: -1}
+	r.netascii = r.Mode == "netascii" && rawTransferMode(rf) != "netascii"
+	r.requested = transferOptions(rf)
+	if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+		r.RemoteAddr = ot.RemoteAddr()
+	}
+	if pi, ok := rf.(tftp.RequestPacketInfo); ok {
+		r.LocalIP = pi.LocalIP()
+	}
+	r.ctx = context.WithValue(ctx, caddy.ReplacerCtxKey, r.newReplacer())
+	return r
+}
+
+func newWriteRequest(ctx context.Context, server, filename, root string, wt io.WriterTo) *Request {
+	r := &Request{ID: uuid.NewString(), Method: MethodWrite, Mode: transferMode(wt), Filename: filename, Root: root, server: server, wt: wt}
+	r.netascii = r.Mode == "netascii" && rawTransferMode(wt) != "netascii"
+	r.requested = transferOptions(wt)
+	if it, ok := wt.(tftp.IncomingTransfer); ok {
+		r.RemoteAddr = it.RemoteAddr()
+	}
+	if pi, ok := wt.(tftp.RequestPacketInfo); ok {
+		r.LocalIP = pi.LocalIP()
+	}
+	r.ctx = context.WithValue(ctx, caddy.ReplacerCtxKey, r.newReplacer())
+	return r
+}
+
+// transferMode returns the mode of a transfer of the tftp library, which
+// does not expose it. Modes are case-insensitive, and any mode other
+// than netascii is served as octet. The library only recognizes netascii
+// in lower case, so the request translates other spellings itself.
+func transferMode(transfer any) string {
+	if strings.EqualFold(rawTransferMode(transfer), "netascii") {
+		return "netascii"
+	}
+	return "octet"
+}
+
+// rawTransferMode returns the mode of a transfer as the client sent it.
+func rawTransferMode(transfer any) string {
+	v := reflect.ValueOf(transfer)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() == reflect.Struct {
+		if mode := v.FieldByName("mode"); mode.Kind() == reflect.String {
+			return mode.String()
+		}
+	}
+	return ""
+}
+
+// transferOptions returns the options of a transfer of the tftp library,
+// which does not expose them: the ones the client requested until the
+// transfer replaces them with the negotiated ones once it starts.
+func transferOptions(transfer any) map[string]string {
+	v := reflect.ValueOf(transfer)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	opts := v.FieldByName("opts")
+	if opts.Kind() != reflect.Map || opts.Len() == 0 {
+		return nil
+	}
+	requested := make(map[string]string, opts.Len())
+	for iter := opts.MapRange(); iter.Next(); {
+		requested[iter.Key().String()] = iter.Value().String()
+	}
+	return requested
+}
+
+// transferDatagrams returns the datagrams a transfer of the tftp library
+// sent so far and how many of them were acknowledged, which it only
+// exposes to the hook of the server.
+func transferDatagrams(transfer any) (sent, acked int) {
+	v := reflect.ValueOf(transfer)
+	if v.Kind() == reflect.Pointer {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0, 0
+	}
+	if f := v.FieldByName("datagramsSent"); f.Kind() == reflect.Int {
+		sent = int(f.Int())
+	}
+	if f := v.FieldByName("datagramsAcked"); f.Kind() == reflect.Int {
+		acked = int(f.Int())
+	}
+	return sent, acked
+}
+
+// newReplacer returns a replacer that provides the placeholders
+// of the request in addition to the global ones:
+//
+//	{tftp.transfer.id}          the unique ID of the transfer
+//	{tftp.request.method}       RRQ or WRQ
+//	{tftp.request.mode}         octet or netascii
+//	{tftp.request.filename}     the (possibly rewritten) file name
+//	{tftp.request.file}         the last element of the file name
+//	{tftp.request.root}         the root of the server
+//	{tftp.request.remote}       the address of the client
+//	{tftp.request.remote.host}  the IP address of the client
+//	{tftp.request.remote.port}  the port of the client
+//	{tftp.client.ip}            the IP address of the client
+//	{tftp.server.name}          the name of the server
+//	{tftp.server.ip}            the IP address the request was received on
+//	{tftp.options.requested.*}  an option requested by the client, e.g. blksize
+//	{tftp.options.*}            an option accepted by the server once the transfer ended
+//	{tftp.transfer.retransmits} the datagrams retransmitted once the transfer ended
+func (r *Request) newReplacer() *caddy.Replacer {
+	repl := caddy.NewReplacer()
+	repl.Map(func(key string) (any, bool) {
+		switch key {
+		case "tftp.transfer.id":
+			return r.ID, true
+		case "tftp.request.method":
+			return r.Method, true
+		case "tftp.request.mode":
+			return r.Mode, true
+		case "tftp.request.filename":
+			return r.Filename, true
+		case "tftp.request.file":
+			return path.Base(path.Clean("/" + r.Filename)), true
+		case "tftp.request.root":
+			return r.Root, true
+		case "tftp.request.remote":
+			return r.RemoteAddr.String(), true
+		case "tftp.request.remote.host", "tftp.client.ip":
+			return r.RemoteAddr.IP.String(), true
+		case "tftp.request.remote.port":
+			return r.RemoteAddr.Port, true
+		case "tftp.server.name":
+			return r.server, true
+		case "tftp.server.ip":
+			if r.LocalIP == nil {
+				return "", true
+			}
+			return r.LocalIP.String(), true
+		case "tftp.transfer.retransmits":
+			return r.retransmits, true
+		}
+		if name, ok := strings.CutPrefix(key, "tftp.options.requested."); ok {
+			return r.requested[name], true
+		}
+		if name, ok := strings.CutPrefix(key, "tftp.options."); ok {
+			return r.options[name], true
+		}
+		return nil, false
+	})
+	return repl
+}
+
+// Context returns the context of the request.
+func (r *Request) Context() context.Context {
+	return r.ctx
+}
+
+// Replacer returns the placeholder replacer of the request.
+func (r *Request) Replacer() *caddy.Replacer {
+	return r.ctx.Value(caddy.ReplacerCtxKey).(*caddy.Replacer)
+}
+
+// ReadFrom sends the contents of rd to the client.
+// It may only be called once, and only for read requests.
+func (r *Request) ReadFrom(rd io.Reader) (int64, error) {
+	if r.rf == nil {
+		return 0, errors.New("cannot send data in response to a write request")
+	}
+	if r.maxBytes > 0 && r.size > r.maxBytes {
+		return 0, errFileTooLarge
+	}
+	// data in memory gains nothing from read-ahead but another copy
+	if _, ok := rd.(*bytes.Reader); !ok {
+		br := getReader(rd)
+		defer putReader(br)
+		rd = br
+	}
+	if r.netascii {
+		rd = netascii.ToReader(rd)
+	}
+	if len(r.limiters) > 0 {
+		rd = &throttledReader{ctx: r.ctx, r: rd, limiters: r.limiters}
+	}
+	r.started = true
+	rd = &countingReader{r: rd, req: r}
+	if r.packetLog != nil {
+		rd = &packetReader{r: rd, req: r}
+	}
+	return r.rf.ReadFrom(rd)
+}
+
+// capture returns a copy of the read request r whose data is sent to rf
+// instead of the client, for handlers that transform what the next ones
+// send. Throttling, line ending translation and packet logs are left to
+// the final send, so they apply once.
+func (r *Request) capture(rf io.ReaderFrom) *Request {
+	rec := *r
+	rec.rf = rf
+	rec.n = 0
+	rec.size = -1
+	rec.limiters = nil
+	rec.started = false
+	rec.netascii = false
+	rec.packetLog = nil
+	return &rec
+}
+
+// WriteTo writes the data uploaded by the client to w.
+// It may only be called once, and only for write requests.
+func (r *Request) WriteTo(w io.Writer) (int64, error) {
+	if r.wt == nil {
+		return 0, errors.New("cannot receive data in response to a read request")
+	}
+	bw := getWriter(w)
+	defer putWriter(bw)
+	w = bw
+	if r.netascii {
+		w = netascii.FromWriter(w)
+	}
+	if len(r.limiters) > 0 {
+		w = &throttledWriter{ctx: r.ctx, w: w, limiters: r.limiters}
+	}
+	r.started = true
+	w = &countingWriter{w: w, req: r}
+	if r.packetLog != nil {
+		w = &packetWriter{w: w, req: r}
+	}
+	n, err := r.wt.WriteTo(w)
+	if err != nil {
+		return n, err
+	}
+	return n, bw.Flush()
+}
+
+// afterUpload registers f to run in the background once the upload r is
+// done and logged, so it does not hold up the final acknowledgement of
+// the client.
+func (r *Request) afterUpload(f func()) {
+	r.after = append(r.after, f)
+}
+
+// touch postpones the idle timeout of the request.
+func (r *Request) touch() {
+	if r.idle != nil {
+		r.idle.Reset(r.idleTimeout)
+	}
+}
+
+// Bytes returns the number of bytes transferred so far.
+func (r *Request) Bytes() int64 {
+	return atomic.LoadInt64(&r.n)
+}
+
+// countingReader counts the bytes sent for a request, postponing its
+// idle timeout, and stops once the request is cancelled or exceeds its
+// size limit.
+type countingReader struct {
+	r   io.Reader
+	req *Request
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	if c.req.ctx.Err() != nil {
+		return 0, context.Cause(c.req.ctx)
+	}
+	n, err := c.r.Read(p)
+	if c.req.maxBytes > 0 && atomic.LoadInt64(&c.req.n)+int64(n) > c.req.maxBytes {
+		return 0, errFileTooLarge
+	}
+	atomic.AddInt64(&c.req.n, int64(n))
+	c.req.touch()
+	return n, err
+}
+
+// countingWriter counts the bytes received for a request, postponing
+// its idle timeout, and stops once the request is cancelled or exceeds
+// its size limit.
+type countingWriter struct {
+	w   io.Writer
+	req *Request
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.req.ctx.Err() != nil {
+		return 0, context.Cause(c.req.ctx)
+	}
+	if c.req.maxBytes > 0 && atomic.LoadInt64(&c.req.n)+int64(len(p)) > c.req.maxBytes {
+		return 0, errFileTooLarge
+	}
+	n, err := c.w.Write(p)
+	atomic.AddInt64(&c.req.n, int64(n))
+	c.req.touch()
+	return n, err
+}
+
+// SetSize announces the size of the file that is about to be sent
+// (the tsize option of RFC 2349). It must be called before ReadFrom.
+// Netascii downloads do not announce it, as translating line endings
+// changes the size on the wire.
+func (r *Request) SetSize(n int64) {
+	r.size = n
+	if r.Mode == "netascii" {
+		return
+	}
+	if ot, ok := r.rf.(tftp.OutgoingTransfer); ok {
+		ot.SetSize(n)
+	}
+}
+
+// Size returns the size of the file being uploaded if the client
+// announced it with the tsize option. It is unknown for netascii
+// uploads, whose size on the wire differs from the one stored.
+func (r *Request) Size() (int64, bool) {
+	if r.Mode == "netascii" {
+		return 0, false
+	}
+	if it, ok := r.wt.(tftp.IncomingTransfer); ok {
+		return it.Size()
+	}
+	return 0, false
+}
+
+// errAccessViolation is returned for requests the client may not make.
+var errAccessViolation = errors.New("access violation")
+
+// errFileTooLarge is returned for transfers exceeding max_upload_size
+// or max_download_size.
+var errFileTooLarge = errors.New("file too large")
+
+// errTransferTooLong is returned for transfers exceeding max_transfer_duration.
+var errTransferTooLong = errors.New("transfer took too long")
+
+// errTransferIdle is returned for transfers exceeding idle_timeout.
+var errTransferIdle = errors.New("transfer idle for too long")
+
+// errServerBusy is returned when the server cannot take on more transfers.
+var errServerBusy = errors.New("server busy, try again later")
+
+// errDraining is returned for new requests while a server is draining.
+var errDraining = errors.New("server draining, try again later")
+
+// errTooManyRequests is returned for requests beyond max_requests_per_client.
+var errTooManyRequests = errors.New("too many requests, try again later")
+
+// TFTP error codes (RFC 1350).
+const (
+	errCodeNotDefined       = 0
+	errCodeFileNotFound     = 1
+	errCodeAccessViolation  = 2
+	errCodeDiskFull         = 3
+	errCodeIllegalOperation = 4
+	errCodeFileAlreadyExist = 6
+	errCodeOptionNegotiate  = 8
+)
+
+// errorCode returns the TFTP error code that describes err.
+func errorCode(err error) int {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return errCodeFileNotFound
+	case errors.Is(err, fs.ErrPermission),
+		errors.Is(err, errAccessViolation),
+		errors.Is(err, errSymlink):
+		return errCodeAccessViolation
+	case errors.Is(err, syscall.ENOSPC),
+		errors.Is(err, errFileTooLarge),
+		errors.Is(err, errQuotaExceeded):
+		return errCodeDiskFull
+	case errors.Is(err, fs.ErrExist):
+		return errCodeFileAlreadyExist
+	case errors.Is(err, errUnknownMode),
+		errors.Is(err, errMailMode):
+		return errCodeIllegalOperation
+	case errors.Is(err, errInvalidOption):
+		return errCodeOptionNegotiate
+	}
+	return errCodeNotDefined
+}
+
+// Handler is like MiddlewareHandler except it has no next handler.
+type Handler interface {
+	ServeTFTP(*Request) error
+}
+
+// HandlerFunc is a convenience type like http.HandlerFunc.
+type HandlerFunc func(*Request) error
+
+// ServeTFTP implements the Handler interface.
+func (f HandlerFunc) ServeTFTP(r *Request) error {
+	return f(r)
+}
+
+// MiddlewareHandler is a handler in the tftp.handlers namespace.
+// It either serves the request itself or passes it on to next.
+type MiddlewareHandler interface {
+	ServeTFTP(r *Request, next Handler) error
+}
+
+// notFoundHandler terminates every pipeline; it is reached
+// when no handler served the request.
+var notFoundHandler = HandlerFunc(func(*Request) error {
+	return fs.ErrNotExist
+})
+
+// compileHandlers chains handlers into a single Handler,
+// ending with last.
+func compileHandlers(handlers []MiddlewareHandler, last Handler) Handler {
+	next := last
+	for i := len(handlers) - 1; i >= 0; i-- {
+		next = wrapMiddleware(handlers[i], next)
+	}
+	return next
+}
+
+func wrapMiddleware(mh MiddlewareHandler, next Handler) Handler {
+	return HandlerFunc(func(r *Request) error {
+		return mh.ServeTFTP(r, next)
+	})
+}
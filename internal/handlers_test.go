@@ -0,0 +1,172 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/pin/tftp/v3"
+)
+
+// middlewareFunc adapts a function to MiddlewareHandler.
+type middlewareFunc func(r *Request, next Handler) error
+
+func (f middlewareFunc) ServeTFTP(r *Request, next Handler) error {
+	return f(r, next)
+}
+
+func TestCompileHandlers(t *testing.T) {
+	var calls []string
+	record := func(name string) MiddlewareHandler {
+		return middlewareFunc(func(r *Request, next Handler) error {
+			calls = append(calls, name+":"+r.Filename)
+			r.Filename = name + "/" + r.Filename
+			return next.ServeTFTP(r)
+		})
+	}
+	h := compileHandlers([]MiddlewareHandler{record("a"), record("b")}, HandlerFunc(func(r *Request) error {
+		calls = append(calls, "last:"+r.Filename)
+		return nil
+	}))
+	if err := h.ServeTFTP(&Request{Filename: "x"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"a:x", "b:a/x", "last:b/a/x"}; !slices.Equal(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+}
+
+func TestPipeline(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "disk.img"), []byte("from disk"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, &Server{Root: root},
+		&StaticResponse{MatchFiles: []string{"boot.ipxe"}, Body: "#!ipxe"},
+		&FileServer{},
+	)
+
+	if got, err := download(t, addr, "boot.ipxe"); err != nil || got != "#!ipxe" {
+		t.Errorf("boot.ipxe: got %q, %v", got, err)
+	}
+	// passed on to the file server by static_response
+	if got, err := download(t, addr, "disk.img"); err != nil || got != "from disk" {
+		t.Errorf("disk.img: got %q, %v", got, err)
+	}
+	_, err := download(t, addr, "missing")
+	wantCode(t, err, errCodeFileNotFound)
+
+	if err := upload(t, addr, "up.txt", "uploaded"); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := download(t, addr, "up.txt"); err != nil || got != "uploaded" {
+		t.Errorf("up.txt: got %q, %v", got, err)
+	}
+	// the default overwrite policy is deny
+	wantCode(t, upload(t, addr, "up.txt", "again"), errCodeFileAlreadyExist)
+}
+
+func TestPipelineEnd(t *testing.T) {
+	addr := startServer(t, &Server{}, &StaticResponse{MatchFiles: []string{"a"}, Body: "a"})
+	_, err := download(t, addr, "b")
+	wantCode(t, err, errCodeFileNotFound)
+}
+
+func TestNetascii(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "motd"), []byte("a\nb\r"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, &Server{Root: root}, &FileServer{})
+
+	for _, tc := range []struct {
+		mode string
+		want string
+	}{
+		// translated back by the client
+		{"netascii", "a\nb\r"},
+		// translated by the server, but not by the client
+		{"NETASCII", "a\r\nb\r\x00"},
+		{"octet", "a\nb\r"},
+	} {
+		c := newClient(t, addr)
+		c.RequestTSize(true)
+		wt, err := c.Receive("motd", tc.mode)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.mode, err)
+		}
+		// the size stored differs from the translated one; the client
+		// reports the tsize of 0 it requested if the server has no answer
+		size, ok := wt.(tftp.IncomingTransfer).Size()
+		if tc.mode != "octet" && ok && size != 0 {
+			t.Errorf("%s: announced tsize %d", tc.mode, size)
+		}
+		if tc.mode == "octet" && (!ok || size != 4) {
+			t.Errorf("%s: announced tsize %d, %v, want 4", tc.mode, size, ok)
+		}
+		var buf bytes.Buffer
+		if _, err := wt.WriteTo(&buf); err != nil {
+			t.Fatalf("%s: %v", tc.mode, err)
+		}
+		if buf.String() != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.mode, buf.String(), tc.want)
+		}
+	}
+}
+
+// TestTransferReflection fails if an upgrade of pin/tftp renames the
+// unexported fields of its transfers that the server reads by reflection.
+func TestTransferReflection(t *testing.T) {
+	type seen struct {
+		mode       string
+		options    map[string]string
+		sent, acks int
+	}
+	var mu sync.Mutex
+	got := make(map[string]seen)
+	addr := startServer(t, &Server{}, middlewareFunc(func(r *Request, next Handler) error {
+		var err error
+		transfer := any(r.rf)
+		if r.Method == MethodRead {
+			_, err = r.ReadFrom(strings.NewReader(strings.Repeat("x", 1000)))
+		} else {
+			transfer = r.wt
+			_, err = r.WriteTo(io.Discard)
+		}
+		s := seen{mode: rawTransferMode(transfer), options: r.requested}
+		s.sent, s.acks = transferDatagrams(transfer)
+		mu.Lock()
+		got[r.Method] = s
+		mu.Unlock()
+		return err
+	}))
+
+	c := newClient(t, addr)
+	c.SetBlockSize(600)
+	wt, err := c.Receive("file", "netascii")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := wt.WriteTo(io.Discard); err != nil {
+		t.Fatal(err)
+	}
+	if err := upload(t, addr, "file", strings.Repeat("x", 1000)); err != nil {
+		t.Fatal(err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	// the OACK and two blocks
+	if s := got[MethodRead]; s.mode != "netascii" || s.options["blksize"] != "600" || s.sent != 3 || s.acks != 3 {
+		t.Errorf("download: got %+v, want mode netascii, blksize 600 and 3 datagrams sent and acknowledged", s)
+	}
+	// the client sends the final ACK after the handler returns
+	if s := got[MethodWrite]; s.mode != "octet" || s.sent == 0 || s.acks == 0 {
+		t.Errorf("upload: got %+v, want mode octet and datagrams sent and acknowledged", s)
+	}
+}
@@ -0,0 +1,18 @@
+package internal
+
+// UploadHook is a module in the tftp.hooks namespace that the file
+// server runs once it stored an upload at path. Besides the placeholders
+// of the request, the replacer of r provides:
+//
+//	{tftp.upload.path}    the path of the stored file
+//	{tftp.upload.size}    the size of the stored file in bytes
+//	{tftp.upload.sha256}  the checksum of the stored file, if write_checksums is enabled
+//
+// Hooks run in the background once the upload is done, as the tftp library
+// holds the final acknowledgement of the client until the handlers
+// returned, so errors are only logged. The uploads of different clients
+// may run their hooks at the same time. r.Context() is the context of
+// the server by then, which is done once its config is unloaded.
+type UploadHook interface {
+	RunHook(r *Request, path string) error
+}
@@ -0,0 +1,174 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(HTTPAuthorizer{})
+}
+
+// HTTPAuthorizer asks an HTTP(S) endpoint whether a request may proceed,
+// e.g. an inventory system that knows which host may fetch which image.
+// It POSTs a JSON object describing the request:
+//
+//	{
+//	    "server":      "pxe",
+//	    "filename":    "images/host1.img",
+//	    "method":      "read",
+//	    "mode":        "octet",
+//	    "remote_addr": "192.0.2.10:50123"
+//	}
+//
+// A 2xx response allows the request and a 401 or 403 response denies it.
+// Other responses and failed requests deny it as well, but are logged
+// as errors.
+type HTTPAuthorizer struct {
+	// The URL to POST to. Placeholders are supported.
+	URL string `json:"url,omitempty"`
+
+	// Headers to add to the request, e.g. for authentication.
+	// Placeholders are supported in the values.
+	Headers http.Header `json:"headers,omitempty"`
+
+	// The maximum time the request may take; clients retransmit
+	// their request if they get no answer in time.
+	// Default is 5 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	client *http.Client
+}
+
+// authorizePayload is the body of an authorization request.
+type authorizePayload struct {
+	Server     string `json:"server"`
+	Filename   string `json:"filename"`
+	Method     string `json:"method"`
+	Mode       string `json:"mode"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (HTTPAuthorizer) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.authorizers.http",
+		New: func() caddy.Module { return new(HTTPAuthorizer) },
+	}
+}
+
+// Provision sets up the HTTP client.
+func (ha *HTTPAuthorizer) Provision(_ caddy.Context) error {
+	if ha.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	timeout := time.Duration(ha.Timeout)
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	ha.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// Authorize implements Authorizer.
+func (ha *HTTPAuthorizer) Authorize(r *Request) (bool, error) {
+	method := "read"
+	if r.Method == MethodWrite {
+		method = "write"
+	}
+	payload, err := json.Marshal(authorizePayload{
+		Server:     r.server,
+		Filename:   r.Filename,
+		Method:     method,
+		Mode:       r.Mode,
+		RemoteAddr: r.RemoteAddr.String(),
+	})
+	if err != nil {
+		return false, err
+	}
+
+	repl := r.Replacer()
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, repl.ReplaceAll(ha.URL, ""), bytes.NewReader(payload))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, values := range ha.Headers {
+		for _, value := range values {
+			req.Header.Add(name, repl.ReplaceAll(value, ""))
+		}
+	}
+	resp, err := ha.client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode <= 299:
+		return true, nil
+	case resp.StatusCode == http.StatusUnauthorized || resp.StatusCode == http.StatusForbidden:
+		return false, nil
+	default:
+		return false, fmt.Errorf("authorizer responded with status %s", resp.Status)
+	}
+}
+
+// UnmarshalCaddyfile sets up the authorizer from Caddyfile tokens.
+//
+//	http <url> {
+//	    header  <name> <value>
+//	    timeout <duration>
+//	}
+func (ha *HTTPAuthorizer) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume authorizer name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	ha.URL = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "header":
+			var name, value string
+			if !d.Args(&name, &value) {
+				return d.ArgErr()
+			}
+			if ha.Headers == nil {
+				ha.Headers = make(http.Header)
+			}
+			ha.Headers.Add(name, value)
+		case "timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing timeout duration: %v", err)
+			}
+			ha.Timeout = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized http authorizer option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*HTTPAuthorizer)(nil)
+	_ Authorizer            = (*HTTPAuthorizer)(nil)
+	_ caddyfile.Unmarshaler = (*HTTPAuthorizer)(nil)
+)
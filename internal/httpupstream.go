@@ -0,0 +1,192 @@
+package internal
+
+import (
+	"fmt"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(HTTPUpstream{})
+}
+
+// HTTPUpstream serves downloads by fetching them from an HTTP(S) server.
+// Uploads are passed on to the next handler.
+type HTTPUpstream struct {
+	// The URL to fetch, typically containing the {tftp.request.filename}
+	// placeholder, e.g. http://artifacts.internal/boot/{tftp.request.filename}
+	// The values of the tftp.* placeholders are escaped for the path of
+	// the URL: file names are cleaned, so they cannot leave the path
+	// they are placed in, and escaped element by element.
+	URL string `json:"url,omitempty"`
+
+	// Headers to add to the upstream request.
+	// Placeholders are supported in the values.
+	Headers http.Header `json:"headers,omitempty"`
+
+	// The maximum time to wait for the upstream to respond.
+	// Default is 30 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	client *http.Client
+	log    *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (HTTPUpstream) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.http_upstream",
+		New: func() caddy.Module { return new(HTTPUpstream) },
+	}
+}
+
+// Provision sets up the HTTP client.
+func (h *HTTPUpstream) Provision(ctx caddy.Context) error {
+	h.log = ctx.Logger()
+	if h.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	timeout := time.Duration(h.Timeout)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	// the timeout only covers waiting for the response headers, since
+	// streaming the body is paced by the (slow) TFTP transfer
+	h.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (h *HTTPUpstream) ServeTFTP(r *Request, next Handler) error {
+	if r.Method != MethodRead {
+		return next.ServeTFTP(r)
+	}
+	repl := r.Replacer()
+	u, err := replaceURL(repl, h.URL)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	for name, values := range h.Headers {
+		for _, value := range values {
+			req.Header.Add(name, repl.ReplaceAll(value, ""))
+		}
+	}
+	h.log.Debug("fetching from upstream", zap.String("transfer_id", r.ID), zap.String("url", u))
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotFound:
+		return fs.ErrNotExist
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		return fs.ErrPermission
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("upstream responded with status %s", resp.Status)
+	}
+	if resp.ContentLength >= 0 {
+		r.SetSize(resp.ContentLength)
+	}
+	_, err = r.ReadFrom(resp.Body)
+	return err
+}
+
+// replaceURL replaces the placeholders in the URL template u. The values
+// of the tftp.* placeholders are chosen by the client, so they are
+// escaped to stay within the path of the URL: file names are cleaned and
+// escaped element by element, other values as a single element.
+func replaceURL(repl *caddy.Replacer, u string) (string, error) {
+	return repl.ReplaceFunc(u, func(variable string, val any) (any, error) {
+		if val == nil || !strings.HasPrefix(variable, "tftp.") {
+			return val, nil
+		}
+		switch variable {
+		case "tftp.request.filename", "tftp.request.root", "tftp.upload.path":
+			return escapeURLPath(caddy.ToString(val)), nil
+		}
+		return url.PathEscape(caddy.ToString(val)), nil
+	})
+}
+
+// escapeURLPath cleans the slash-separated name, so it has no . or ..
+// elements and no leading slash, and escapes each element for a URL path.
+func escapeURLPath(name string) string {
+	elems := strings.Split(strings.TrimPrefix(path.Clean("/"+name), "/"), "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+//
+//	http_upstream <url> {
+//	    header  <name> <value>
+//	    timeout <duration>
+//	}
+func (h *HTTPUpstream) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	h.URL = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "header":
+			var name, value string
+			if !d.Args(&name, &value) {
+				return d.ArgErr()
+			}
+			if h.Headers == nil {
+				h.Headers = make(http.Header)
+			}
+			h.Headers.Add(name, value)
+		case "timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing timeout duration: %v", err)
+			}
+			h.Timeout = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized http_upstream option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*HTTPUpstream)(nil)
+	_ MiddlewareHandler     = (*HTTPUpstream)(nil)
+	_ caddyfile.Unmarshaler = (*HTTPUpstream)(nil)
+)
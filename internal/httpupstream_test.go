@@ -0,0 +1,123 @@
+package internal
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestHTTPUpstream(t *testing.T) {
+	large := strings.Repeat("0123456789", 1000)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Header.Get("X-Client") != "127.0.0.1" {
+			http.Error(w, "missing header", http.StatusBadRequest)
+			return
+		}
+		switch req.URL.Path {
+		case "/boot/pxelinux.0":
+			_, _ = io.WriteString(w, "pxelinux")
+		case "/boot/large.img":
+			_, _ = io.WriteString(w, large)
+		case "/boot/secret":
+			w.WriteHeader(http.StatusForbidden)
+		case "/boot/broken":
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			http.NotFound(w, req)
+		}
+	}))
+	defer srv.Close()
+
+	root := t.TempDir()
+	addr := startServer(t, &Server{Root: root},
+		&HTTPUpstream{URL: srv.URL + "/boot/{tftp.request.filename}", Headers: http.Header{"X-Client": {"{tftp.client.ip}"}}},
+		&FileServer{},
+	)
+
+	if got, err := download(t, addr, "pxelinux.0"); err != nil || got != "pxelinux" {
+		t.Errorf("pxelinux.0: got %q, %v", got, err)
+	}
+	if got, err := download(t, addr, "large.img"); err != nil || got != large {
+		t.Errorf("large.img: got %d bytes, %v", len(got), err)
+	}
+	_, err := download(t, addr, "missing")
+	wantCode(t, err, errCodeFileNotFound)
+	_, err = download(t, addr, "secret")
+	wantCode(t, err, errCodeAccessViolation)
+	_, err = download(t, addr, "broken")
+	wantCode(t, err, errCodeNotDefined)
+
+	// uploads are passed on to the next handler
+	if err := upload(t, addr, "up.txt", "uploaded"); err != nil {
+		t.Fatal(err)
+	}
+	if data, err := os.ReadFile(filepath.Join(root, "up.txt")); err != nil || string(data) != "uploaded" {
+		t.Errorf("up.txt: %q, %v", data, err)
+	}
+}
+
+func TestHTTPUpstreamEscapesFilename(t *testing.T) {
+	var got []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		got = append(got, req.URL.EscapedPath()+"?"+req.URL.RawQuery)
+		_, _ = io.WriteString(w, "content")
+	}))
+	defer srv.Close()
+
+	h := &HTTPUpstream{URL: srv.URL + "/boot/{tftp.request.filename}?v=1", client: srv.Client(), log: zap.NewNop()}
+	for _, tc := range []struct {
+		filename string
+		want     string
+	}{
+		{"pxelinux.0", "/boot/pxelinux.0?v=1"},
+		{"a?b=c", "/boot/a%3Fb=c?v=1"},
+		{"../x", "/boot/x?v=1"},
+		{"%2e%2e/x", "/boot/%252e%252e/x?v=1"},
+		{"efi/../../grub#frag", "/boot/grub%23frag?v=1"},
+		{"/efi//bootx64.efi", "/boot/efi/bootx64.efi?v=1"},
+	} {
+		got = nil
+		var buf bytes.Buffer
+		r := newReadRequest(context.Background(), "test", tc.filename, "", bufferReaderFrom{&buf})
+		if err := h.ServeTFTP(r, notFoundHandler); err != nil {
+			t.Fatalf("%q: %v", tc.filename, err)
+		}
+		if len(got) != 1 || got[0] != tc.want {
+			t.Errorf("%q: requested %q, want %q", tc.filename, got, tc.want)
+		}
+		if buf.String() != "content" {
+			t.Errorf("%q: sent %q", tc.filename, buf.String())
+		}
+	}
+}
+
+func TestReplaceURL(t *testing.T) {
+	r := newWriteRequest(context.Background(), "test", "configs/sw 1?.cfg", "", nil)
+	repl := r.Replacer()
+	repl.Set("tftp.upload.path", "/srv/tftp/configs/sw 1?.cfg")
+	for _, tc := range []struct {
+		template string
+		want     string
+	}{
+		{"https://backup/{tftp.request.file}", "https://backup/sw%201%3F.cfg"},
+		{"https://backup/{tftp.request.filename}", "https://backup/configs/sw%201%3F.cfg"},
+		{"https://backup/{tftp.upload.path}", "https://backup/srv/tftp/configs/sw%201%3F.cfg"},
+		{"https://{env.NO_SUCH_VARIABLE}backup/{tftp.server.name}", "https://backup/test"},
+	} {
+		got, err := replaceURL(repl, tc.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("replaceURL(%q) = %q, want %q", tc.template, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !unix || solaris
+
+package internal
+
+// ownsSocket reports whether the tftp server may use and close the socket
+// underneath Caddy's listener. On these platforms the socket is shared with
+// the servers of the next config on reload, so it must stay wrapped.
+const ownsSocket = false
@@ -0,0 +1,8 @@
+//go:build unix && !solaris
+
+package internal
+
+// ownsSocket reports whether the tftp server may use and close the socket
+// underneath Caddy's listener. On these platforms every config gets its own
+// socket through SO_REUSEPORT, so a reload never shares it.
+const ownsSocket = true
@@ -0,0 +1,287 @@
+package internal
+
+import (
+	"fmt"
+	"net/netip"
+	"path"
+	"regexp"
+	"slices"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(MatchFile{})
+	caddy.RegisterModule(MatchFileRE{})
+	caddy.RegisterModule(MatchRemoteIP{})
+	caddy.RegisterModule(MatchMode{})
+	caddy.RegisterModule(MatchMethod{})
+}
+
+// RequestMatcher is a module in the tftp.matchers namespace,
+// which decides whether a request is passed to a route.
+type RequestMatcher interface {
+	Match(*Request) bool
+}
+
+// MatcherSet is a set of matchers which
+// must all match for the set to match.
+type MatcherSet []RequestMatcher
+
+// Match reports whether all matchers in the set match r.
+func (ms MatcherSet) Match(r *Request) bool {
+	for _, m := range ms {
+		if !m.Match(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// MatcherSets is a group of matcher sets, which match
+// if any of the sets match. An empty group matches all requests.
+type MatcherSets []MatcherSet
+
+// AnyMatch reports whether any of the matcher sets match r.
+func (mss MatcherSets) AnyMatch(r *Request) bool {
+	for _, ms := range mss {
+		if ms.Match(r) {
+			return true
+		}
+	}
+	return len(mss) == 0
+}
+
+// MatchFile matches requests by glob patterns of the file name, matched
+// like the hide patterns of the server, e.g. "*.ipxe" or "images/*".
+type MatchFile []string
+
+// CaddyModule returns the Caddy module information.
+func (MatchFile) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.matchers.file",
+		New: func() caddy.Module { return new(MatchFile) },
+	}
+}
+
+// Provision validates the patterns.
+func (m *MatchFile) Provision(_ caddy.Context) error {
+	for _, pattern := range *m {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// Match implements RequestMatcher.
+func (m MatchFile) Match(r *Request) bool {
+	return matchPath(m, r.Filename)
+}
+
+// UnmarshalCaddyfile sets up the matcher from Caddyfile tokens.
+//
+//	file <patterns...>
+func (m *MatchFile) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume matcher name
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		return d.ArgErr()
+	}
+	*m = append(*m, args...)
+	return nil
+}
+
+// MatchFileRE matches requests by a regular expression of the file name.
+type MatchFileRE struct {
+	// The regular expression, in Go's RE2 syntax.
+	Pattern string `json:"pattern,omitempty"`
+
+	re *regexp.Regexp
+}
+
+// CaddyModule returns the Caddy module information.
+func (MatchFileRE) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.matchers.file_regexp",
+		New: func() caddy.Module { return new(MatchFileRE) },
+	}
+}
+
+// Provision compiles the regular expression.
+func (m *MatchFileRE) Provision(_ caddy.Context) error {
+	re, err := regexp.Compile(m.Pattern)
+	if err != nil {
+		return fmt.Errorf("compiling pattern: %v", err)
+	}
+	m.re = re
+	return nil
+}
+
+// Match implements RequestMatcher.
+func (m MatchFileRE) Match(r *Request) bool {
+	return m.re.MatchString(r.Filename)
+}
+
+// UnmarshalCaddyfile sets up the matcher from Caddyfile tokens.
+//
+//	file_regexp <pattern>
+func (m *MatchFileRE) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume matcher name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	if m.Pattern != "" {
+		return d.Err("file_regexp may only be given once per matcher set")
+	}
+	m.Pattern = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	return nil
+}
+
+// MatchRemoteIP matches requests by the IP address of the client.
+type MatchRemoteIP struct {
+	// IP addresses or CIDR ranges.
+	Ranges []string `json:"ranges,omitempty"`
+
+	prefixes []netip.Prefix
+}
+
+// CaddyModule returns the Caddy module information.
+func (MatchRemoteIP) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.matchers.remote_ip",
+		New: func() caddy.Module { return new(MatchRemoteIP) },
+	}
+}
+
+// Provision parses the ranges.
+func (m *MatchRemoteIP) Provision(_ caddy.Context) error {
+	prefixes, err := parsePrefixes(m.Ranges)
+	if err != nil {
+		return err
+	}
+	m.prefixes = prefixes
+	return nil
+}
+
+// Match implements RequestMatcher.
+func (m MatchRemoteIP) Match(r *Request) bool {
+	return len(m.prefixes) > 0 && allowedBy(r.RemoteAddr.IP, m.prefixes, nil)
+}
+
+// UnmarshalCaddyfile sets up the matcher from Caddyfile tokens.
+//
+//	remote_ip <cidrs...>
+func (m *MatchRemoteIP) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume matcher name
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		return d.ArgErr()
+	}
+	m.Ranges = append(m.Ranges, args...)
+	return nil
+}
+
+// MatchMode matches requests by transfer mode: octet or netascii.
+type MatchMode []string
+
+// CaddyModule returns the Caddy module information.
+func (MatchMode) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.matchers.mode",
+		New: func() caddy.Module { return new(MatchMode) },
+	}
+}
+
+// Provision validates the modes.
+func (m *MatchMode) Provision(_ caddy.Context) error {
+	for _, mode := range *m {
+		if mode != "octet" && mode != "netascii" {
+			return fmt.Errorf("unrecognized mode '%s'", mode)
+		}
+	}
+	return nil
+}
+
+// Match implements RequestMatcher.
+func (m MatchMode) Match(r *Request) bool {
+	return slices.Contains(m, r.Mode)
+}
+
+// UnmarshalCaddyfile sets up the matcher from Caddyfile tokens.
+//
+//	mode octet|netascii...
+func (m *MatchMode) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume matcher name
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		return d.ArgErr()
+	}
+	*m = append(*m, args...)
+	return nil
+}
+
+// MatchMethod matches requests by direction: read (RRQ) or write (WRQ).
+type MatchMethod []string
+
+// CaddyModule returns the Caddy module information.
+func (MatchMethod) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.matchers.method",
+		New: func() caddy.Module { return new(MatchMethod) },
+	}
+}
+
+// Provision validates the methods.
+func (m *MatchMethod) Provision(_ caddy.Context) error {
+	for _, method := range *m {
+		if method != "read" && method != "write" {
+			return fmt.Errorf("unrecognized method '%s'", method)
+		}
+	}
+	return nil
+}
+
+// Match implements RequestMatcher.
+func (m MatchMethod) Match(r *Request) bool {
+	if r.Method == MethodRead {
+		return slices.Contains(m, "read")
+	}
+	return slices.Contains(m, "write")
+}
+
+// UnmarshalCaddyfile sets up the matcher from Caddyfile tokens.
+//
+//	method read|write...
+func (m *MatchMethod) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume matcher name
+	args := d.RemainingArgs()
+	if len(args) == 0 {
+		return d.ArgErr()
+	}
+	*m = append(*m, args...)
+	return nil
+}
+
+// Interface guards
+var (
+	_ RequestMatcher        = (*MatchFile)(nil)
+	_ caddy.Provisioner     = (*MatchFile)(nil)
+	_ caddyfile.Unmarshaler = (*MatchFile)(nil)
+	_ RequestMatcher        = (*MatchFileRE)(nil)
+	_ caddy.Provisioner     = (*MatchFileRE)(nil)
+	_ caddyfile.Unmarshaler = (*MatchFileRE)(nil)
+	_ RequestMatcher        = (*MatchRemoteIP)(nil)
+	_ caddy.Provisioner     = (*MatchRemoteIP)(nil)
+	_ caddyfile.Unmarshaler = (*MatchRemoteIP)(nil)
+	_ RequestMatcher        = (*MatchMode)(nil)
+	_ caddy.Provisioner     = (*MatchMode)(nil)
+	_ caddyfile.Unmarshaler = (*MatchMode)(nil)
+	_ RequestMatcher        = (*MatchMethod)(nil)
+	_ caddy.Provisioner     = (*MatchMethod)(nil)
+	_ caddyfile.Unmarshaler = (*MatchMethod)(nil)
+)
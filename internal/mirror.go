@@ -0,0 +1,341 @@
+package internal
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(Mirror{})
+}
+
+// Mirror copies uploads to secondary destinations, e.g. to keep
+// off-host copies of the configuration backups of network devices.
+// Uploads are copied under the requested name in the background, so
+// the hook returns right away; failed copies are retried with an
+// increasing delay and logged once all retries failed.
+type Mirror struct {
+	// Directories to copy uploads to. Placeholders are supported.
+	Dirs []string `json:"dirs,omitempty"`
+
+	// URLs to PUT uploads to, e.g.
+	// "https://backup.example.com/tftp/{tftp.request.file}".
+	// Placeholders are supported; the values of the tftp.* placeholders
+	// are escaped like in the url of the http_upstream handler.
+	URLs []string `json:"urls,omitempty"`
+
+	// Headers to add to the PUT requests.
+	// Placeholders are supported in the values.
+	Headers http.Header `json:"headers,omitempty"`
+
+	// Buckets to copy uploads to, configured like the s3 handler; the
+	// key of an object is the prefix followed by the requested name.
+	// The cache_dir and cache_ttl options do not apply.
+	S3 []*S3 `json:"s3,omitempty"`
+
+	// The number of times a failed copy is retried. Default is 5.
+	Retries int `json:"retries,omitempty"`
+
+	// The delay before the first retry, which doubles with every
+	// further retry. Default is 10 seconds.
+	RetryDelay caddy.Duration `json:"retry_delay,omitempty"`
+
+	// The maximum time a PUT request may take.
+	// Default is 30 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	ctx    caddy.Context
+	client *http.Client
+	log    *zap.Logger
+}
+
+// mirrorCopy is a copy of an upload to one destination.
+type mirrorCopy struct {
+	dest string
+	copy func(ctx context.Context, body io.Reader) error
+}
+
+// CaddyModule returns the Caddy module information.
+func (Mirror) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.hooks.mirror",
+		New: func() caddy.Module { return new(Mirror) },
+	}
+}
+
+// Provision sets up the HTTP client and the buckets.
+func (m *Mirror) Provision(ctx caddy.Context) error {
+	m.ctx = ctx
+	m.log = ctx.Logger()
+	if len(m.Dirs) == 0 && len(m.URLs) == 0 && len(m.S3) == 0 {
+		return fmt.Errorf("at least one of dirs, urls and s3 is required")
+	}
+	for i, s := range m.S3 {
+		if err := s.Provision(ctx); err != nil {
+			return fmt.Errorf("s3 %d: %v", i, err)
+		}
+	}
+	if m.Retries <= 0 {
+		m.Retries = 5
+	}
+	if m.RetryDelay <= 0 {
+		m.RetryDelay = caddy.Duration(10 * time.Second)
+	}
+	timeout := time.Duration(m.Timeout)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	m.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// RunHook implements UploadHook.
+func (m *Mirror) RunHook(r *Request, p string) error {
+	// the copies read from the file as it is now, even if it is
+	// replaced by another upload while they are retried
+	file, err := os.Open(p)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	size := info.Size()
+	name := strings.TrimPrefix(path.Clean("/"+r.Filename), "/")
+	repl := r.Replacer()
+
+	var copies []mirrorCopy
+	for _, dir := range m.Dirs {
+		dest := filepath.Join(repl.ReplaceAll(dir, ""), filepath.FromSlash(name))
+		copies = append(copies, mirrorCopy{dest: dest, copy: func(_ context.Context, body io.Reader) error {
+			return copyToFile(dest, body)
+		}})
+	}
+	for _, u := range m.URLs {
+		dest, err := replaceURL(repl, u)
+		if err != nil {
+			file.Close()
+			return err
+		}
+		headers := make(http.Header, len(m.Headers))
+		for name, values := range m.Headers {
+			for _, value := range values {
+				headers.Add(name, repl.ReplaceAll(value, ""))
+			}
+		}
+		copies = append(copies, mirrorCopy{dest: dest, copy: func(ctx context.Context, body io.Reader) error {
+			return m.put(ctx, dest, headers, body, size)
+		}})
+	}
+	if len(m.S3) > 0 {
+		h := sha256.New()
+		if _, err := io.Copy(h, io.NewSectionReader(file, 0, size)); err != nil {
+			file.Close()
+			return err
+		}
+		payloadHash := hex.EncodeToString(h.Sum(nil))
+		for _, s := range m.S3 {
+			key := s.Prefix + name
+			copies = append(copies, mirrorCopy{dest: "s3://" + s.Bucket + "/" + key, copy: func(ctx context.Context, body io.Reader) error {
+				return s.put(ctx, key, body, size, payloadHash)
+			}})
+		}
+	}
+
+	var wg sync.WaitGroup
+	for _, c := range copies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			m.run(r.ID, c, file, size)
+		}()
+	}
+	go func() {
+		wg.Wait()
+		file.Close()
+	}()
+	return nil
+}
+
+// run makes the copy c of the upload in file, retrying it until it
+// succeeds, the retries are exhausted or the config is unloaded.
+func (m *Mirror) run(transferID string, c mirrorCopy, file *os.File, size int64) {
+	delay := time.Duration(m.RetryDelay)
+	for attempt := 0; ; attempt++ {
+		err := c.copy(m.ctx, io.NewSectionReader(file, 0, size))
+		if err == nil {
+			m.log.Debug("mirrored upload", zap.String("transfer_id", transferID), zap.String("dest", c.dest))
+			return
+		}
+		if attempt >= m.Retries {
+			m.log.Error(
+				"mirroring upload failed",
+				zap.String("transfer_id", transferID),
+				zap.String("dest", c.dest),
+				zap.Int("attempts", attempt+1),
+				zap.Error(err),
+			)
+			return
+		}
+		m.log.Warn(
+			"mirroring upload failed, retrying",
+			zap.String("transfer_id", transferID),
+			zap.String("dest", c.dest),
+			zap.Duration("delay", delay),
+			zap.Error(err),
+		)
+		select {
+		case <-time.After(delay):
+		case <-m.ctx.Done():
+			m.log.Error("mirroring upload aborted", zap.String("transfer_id", transferID), zap.String("dest", c.dest))
+			return
+		}
+		delay *= 2
+	}
+}
+
+// put sends body, which has size bytes, to url with a PUT request.
+func (m *Mirror) put(ctx context.Context, url string, headers http.Header, body io.Reader, size int64) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, url, body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	req.Header = headers.Clone()
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s responded with status %s", url, resp.Status)
+	}
+	return nil
+}
+
+// copyToFile stores body at dest, creating its directory if needed.
+// The file is replaced only once it is complete.
+func copyToFile(dest string, body io.Reader) error {
+	dir := filepath.Dir(dest)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".mirror-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = io.Copy(tmp, body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), dest)
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens.
+//
+//	mirror {
+//	    dir    <path>
+//	    url    <url>
+//	    header <name> <value>
+//	    s3 <bucket> {
+//	        ...
+//	    }
+//	    retries     <n>
+//	    retry_delay <duration>
+//	    timeout     <duration>
+//	}
+func (m *Mirror) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume hook name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "dir":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			m.Dirs = append(m.Dirs, d.Val())
+		case "url":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			m.URLs = append(m.URLs, d.Val())
+		case "header":
+			var name, value string
+			if !d.Args(&name, &value) {
+				return d.ArgErr()
+			}
+			if m.Headers == nil {
+				m.Headers = make(http.Header)
+			}
+			m.Headers.Add(name, value)
+		case "s3":
+			s := new(S3)
+			if err := s.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+				return err
+			}
+			m.S3 = append(m.S3, s)
+			continue
+		case "retries":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			n, err := strconv.Atoi(d.Val())
+			if err != nil {
+				return d.Errf("parsing retries: %v", err)
+			}
+			m.Retries = n
+		case "retry_delay", "timeout":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s duration: %v", name, err)
+			}
+			if name == "retry_delay" {
+				m.RetryDelay = caddy.Duration(dur)
+			} else {
+				m.Timeout = caddy.Duration(dur)
+			}
+		default:
+			return d.Errf("unrecognized mirror option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Mirror)(nil)
+	_ UploadHook            = (*Mirror)(nil)
+	_ caddyfile.Unmarshaler = (*Mirror)(nil)
+)
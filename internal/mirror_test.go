@@ -0,0 +1,88 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestMirror(t *testing.T) {
+	var (
+		mu       sync.Mutex
+		attempts int
+		puts     = make(map[string]string)
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		mu.Lock()
+		defer mu.Unlock()
+		// the first attempt fails to have the copy retried
+		if attempts++; attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if req.Method != http.MethodPut || req.Header.Get("X-Client") != "127.0.0.1" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		puts[req.URL.EscapedPath()] = string(body)
+	}))
+	defer srv.Close()
+
+	ctx, cancel := caddy.NewContext(caddy.Context{Context: context.Background()})
+	defer cancel()
+	dir := t.TempDir()
+	m := &Mirror{
+		Dirs:       []string{filepath.Join(dir, "{tftp.client.ip}")},
+		URLs:       []string{srv.URL + "/backup/{tftp.request.filename}"},
+		Headers:    http.Header{"X-Client": {"{tftp.client.ip}"}},
+		RetryDelay: caddy.Duration(50 * time.Millisecond),
+	}
+	if err := m.Provision(ctx); err != nil {
+		t.Fatal(err)
+	}
+	fsrv := &FileServer{}
+	fsrv.hooks = []UploadHook{m}
+	root := t.TempDir()
+	if err := os.Mkdir(filepath.Join(root, "configs"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	addr := startServer(t, &Server{Root: root}, fsrv)
+
+	if err := upload(t, addr, "configs/sw 1.cfg", "hostname sw1"); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		data, ferr := os.ReadFile(filepath.Join(dir, "127.0.0.1", "configs", "sw 1.cfg"))
+		mu.Lock()
+		put, ok := puts["/backup/configs/sw%201.cfg"]
+		mu.Unlock()
+		if ferr == nil && ok {
+			if string(data) != "hostname sw1" {
+				t.Errorf("dir copy: %q", data)
+			}
+			if put != "hostname sw1" {
+				t.Errorf("url copy: %q", put)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("upload not mirrored: %v, %v", ferr, puts)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts != 2 {
+		t.Errorf("got %d PUT requests, want 2", attempts)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !unix
+
+package internal
+
+import (
+	"errors"
+	"os"
+)
+
+// canMmap reports whether files can be memory-mapped.
+const canMmap = false
+
+// mmapFile fails, as memory-mapping is not supported on this platform.
+func mmapFile(*os.File, int64) ([]byte, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func munmap([]byte) error {
+	return errors.ErrUnsupported
+}
@@ -0,0 +1,21 @@
+//go:build unix
+
+package internal
+
+import (
+	"os"
+	"syscall"
+)
+
+// canMmap reports whether files can be memory-mapped.
+const canMmap = true
+
+// mmapFile maps the first size bytes of file into memory read-only.
+// The mapping must be released with munmap.
+func mmapFile(file *os.File, size int64) ([]byte, error) {
+	return syscall.Mmap(int(file.Fd()), 0, int(size), syscall.PROT_READ, syscall.MAP_SHARED)
+}
+
+func munmap(data []byte) error {
+	return syscall.Munmap(data)
+}
@@ -0,0 +1,150 @@
+package internal
+
+import (
+	"io"
+	"strconv"
+
+	"github.com/pin/tftp/v3"
+	"go.uber.org/zap"
+)
+
+// PacketDebug configures the debug logs of the packets of transfers:
+// the request, the options acknowledged with an OACK, every DATA block
+// with its number and size, the ERROR packet of failed transfers and
+// the datagrams sent, acknowledged and retransmitted once a transfer
+// ended. The library sends and receives the packets itself, so the
+// blocks are logged as it hands them over and ACKs are not logged one
+// by one. The logs are emitted at debug level by the "packets" logger
+// of the server.
+type PacketDebug struct {
+	// IP addresses or CIDR ranges of the clients to log the packets of.
+	// Default is all clients.
+	Clients []string `json:"clients,omitempty"`
+}
+
+// packetLogger returns the logger for the packets of r,
+// nil if they are not logged.
+func (s *tftpServer) packetLogger(r *Request) *zap.Logger {
+	if s.packetLog == nil || !allowedBy(r.RemoteAddr.IP, s.packetClients, nil) {
+		return nil
+	}
+	return s.packetLog.With(
+		zap.String("transfer_id", r.ID),
+		zap.String("remote_addr", r.RemoteAddr.String()),
+	)
+}
+
+// logRequest logs the request packet of r.
+func (r *Request) logRequest() {
+	r.packetLog.Debug(
+		"received "+r.Method,
+		zap.String("filename", r.Filename),
+		zap.String("mode", rawTransferMode(r.transfer())),
+		zap.Any("options", r.requested),
+	)
+}
+
+// logOptions logs the options the transfer acknowledged and returns its
+// block size. The library negotiates them before it reads or writes the
+// first block, so they are final by then.
+func (r *Request) logOptions() int {
+	opts := transferOptions(r.transfer())
+	if len(opts) > 0 {
+		r.packetLog.Debug("sent OACK", zap.Any("options", opts))
+	}
+	if n, err := strconv.Atoi(opts["blksize"]); err == nil && n > 0 {
+		return n
+	}
+	return 512
+}
+
+// logError logs the ERROR packet sent for r.
+func (r *Request) logError(err error) {
+	r.packetLog.Debug(
+		"sent ERROR",
+		zap.Int("code", r.errorCode),
+		zap.String("message", err.Error()),
+	)
+}
+
+// logStats logs the datagrams of the transfer of r once it ended.
+func (r *Request) logStats(stats tftp.TransferStats) {
+	r.packetLog.Debug(
+		"transfer ended",
+		zap.Int("datagrams_sent", stats.DatagramsSent),
+		zap.Int("datagrams_acked", stats.DatagramsAcked),
+		zap.Int("retransmits", r.retransmits),
+		zap.Duration("duration", stats.Duration),
+	)
+}
+
+// transfer returns the transfer of the tftp library behind r.
+func (r *Request) transfer() any {
+	if r.rf != nil {
+		return r.rf
+	}
+	return r.wt
+}
+
+// packetReader logs the DATA blocks the library reads for a download.
+// The library fills every block but the last one completely, so the
+// blocks are told apart by the bytes read.
+type packetReader struct {
+	r       io.Reader
+	req     *Request
+	blksize int
+	block   int
+	pending int // the bytes of the block being filled
+	done    bool
+}
+
+func (p *packetReader) Read(b []byte) (int, error) {
+	if p.blksize == 0 {
+		p.blksize = p.req.logOptions()
+	}
+	n, err := p.r.Read(b)
+	p.pending += n
+	for p.pending >= p.blksize {
+		p.pending -= p.blksize
+		p.logBlock(p.blksize)
+	}
+	if err == io.EOF && !p.done {
+		// the last block is shorter than the others, possibly empty
+		p.done = true
+		p.logBlock(p.pending)
+	}
+	return n, err
+}
+
+func (p *packetReader) logBlock(size int) {
+	p.block++
+	p.req.packetLog.Debug(
+		"sent DATA",
+		zap.Int("block", p.block),
+		zap.Int("size", size),
+	)
+}
+
+// packetWriter logs the DATA blocks the library writes for an upload,
+// each of which it acknowledges once written. Netascii uploads are
+// translated before they are written, so their sizes are approximate.
+type packetWriter struct {
+	w       io.Writer
+	req     *Request
+	started bool
+	block   int
+}
+
+func (p *packetWriter) Write(b []byte) (int, error) {
+	if !p.started {
+		p.started = true
+		p.req.logOptions()
+	}
+	p.block++
+	p.req.packetLog.Debug(
+		"received DATA",
+		zap.Int("block", p.block),
+		zap.Int("size", len(b)),
+	)
+	return p.w.Write(b)
+}
@@ -0,0 +1,84 @@
+package internal
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"sync"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// precompressedExts maps the supported precompressed formats
+// to the extension of their files.
+var precompressedExts = map[string]string{
+	"gzip": ".gz",
+	"zstd": ".zst",
+}
+
+// newDecompressor returns a reader of the decompressed contents of r.
+func newDecompressor(format string, r io.Reader) (io.ReadCloser, error) {
+	switch format {
+	case "gzip":
+		return gzip.NewReader(r)
+	case "zstd":
+		d, err := zstd.NewReader(r, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return d.IOReadCloser(), nil
+	default:
+		return nil, fmt.Errorf("unsupported precompressed format '%s'", format)
+	}
+}
+
+// sizeCache remembers the decompressed size of precompressed files,
+// since neither format records it reliably.
+type sizeCache struct {
+	mu sync.Mutex
+	m  map[string]cachedSize
+}
+
+type cachedSize struct {
+	modTime time.Time
+	size    int64 // of the compressed file
+	n       int64 // decompressed
+}
+
+// decompressedSize returns the decompressed size of the file name in
+// fsys, identified by id, decompressing it once if it changed since it
+// was last seen.
+func (c *sizeCache) decompressedSize(fsys fs.FS, id, name, format string, info fs.FileInfo) (int64, error) {
+	key := id + ":" + name
+	c.mu.Lock()
+	cached, ok := c.m[key]
+	c.mu.Unlock()
+	if ok && cached.modTime.Equal(info.ModTime()) && cached.size == info.Size() {
+		return cached.n, nil
+	}
+
+	file, err := fsys.Open(name)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+	dr, err := newDecompressor(format, file)
+	if err != nil {
+		return 0, err
+	}
+	defer dr.Close()
+	n, err := io.Copy(io.Discard, dr)
+	if err != nil {
+		return 0, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.m == nil {
+		c.m = make(map[string]cachedSize)
+	}
+	c.m[key] = cachedSize{modTime: info.ModTime(), size: info.Size(), n: n}
+	return n, nil
+}
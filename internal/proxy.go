@@ -0,0 +1,268 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"net"
+	"sync/atomic"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/pin/tftp/v3"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(Proxy{})
+}
+
+// Proxy forwards requests to one or more upstream TFTP servers.
+//
+// An upstream that does not respond is considered unhealthy for
+// fail_duration, during which requests fail over to the next upstream.
+// Error responses of an upstream are passed on to the client as-is.
+type Proxy struct {
+	// The addresses of the upstream TFTP servers.
+	// The port defaults to 69.
+	Upstreams []string `json:"upstreams,omitempty"`
+
+	// The policy used to select an upstream for a request:
+	// round_robin, first or ip_hash.
+	// Default is round_robin.
+	LBPolicy string `json:"lb_policy,omitempty"`
+
+	// The maximum time to wait for a single network round-trip
+	// with an upstream to succeed.
+	// Default is 5 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// How long an upstream that failed to respond is skipped.
+	// Default is 30 seconds.
+	FailDuration caddy.Duration `json:"fail_duration,omitempty"`
+
+	upstreams []*upstream
+	counter   uint64
+	log       *zap.Logger
+}
+
+type upstream struct {
+	addr        string
+	client      *tftp.Client
+	failedUntil atomic.Int64
+}
+
+func (u *upstream) healthy() bool {
+	return time.Now().UnixNano() >= u.failedUntil.Load()
+}
+
+// CaddyModule returns the Caddy module information.
+func (Proxy) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.proxy",
+		New: func() caddy.Module { return new(Proxy) },
+	}
+}
+
+// Provision sets up the upstreams.
+func (p *Proxy) Provision(ctx caddy.Context) error {
+	p.log = ctx.Logger()
+	if len(p.Upstreams) == 0 {
+		return fmt.Errorf("no upstreams configured")
+	}
+	switch p.LBPolicy {
+	case "":
+		p.LBPolicy = "round_robin"
+	case "round_robin", "first", "ip_hash":
+	default:
+		return fmt.Errorf("unrecognized lb_policy '%s'", p.LBPolicy)
+	}
+	if p.FailDuration <= 0 {
+		p.FailDuration = caddy.Duration(30 * time.Second)
+	}
+	for _, u := range p.Upstreams {
+		addr, err := caddy.ParseNetworkAddressWithDefaults(u, "udp", 69)
+		if err != nil {
+			return fmt.Errorf("parsing upstream %s: %v", u, err)
+		}
+		if addr.Network != "udp" || addr.PortRangeSize() != 1 {
+			return fmt.Errorf("upstream %s: must be a single udp address", u)
+		}
+		hostport := addr.JoinHostPort(0)
+		client, err := tftp.NewClient(hostport)
+		if err != nil {
+			return err
+		}
+		if p.Timeout > 0 {
+			client.SetTimeout(time.Duration(p.Timeout))
+		}
+		client.RequestTSize(true)
+		p.upstreams = append(p.upstreams, &upstream{addr: hostport, client: client})
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (p *Proxy) ServeTFTP(r *Request, _ Handler) error {
+	for _, u := range p.candidates(r) {
+		var err error
+		if r.Method == MethodWrite {
+			err = p.proxyWrite(r, u)
+		} else {
+			err = p.proxyRead(r, u)
+		}
+		var netErr net.Error
+		if errors.As(err, &netErr) {
+			p.log.Warn(
+				"upstream unavailable",
+				zap.String("transfer_id", r.ID),
+				zap.String("upstream", u.addr),
+				zap.Error(err),
+			)
+			u.failedUntil.Store(time.Now().Add(time.Duration(p.FailDuration)).UnixNano())
+			continue
+		}
+		return err
+	}
+	return errors.New("no upstreams available")
+}
+
+// candidates returns the healthy upstreams in the order they should
+// be tried, starting with the one selected by the lb_policy.
+func (p *Proxy) candidates(r *Request) []*upstream {
+	var start int
+	switch p.LBPolicy {
+	case "round_robin":
+		start = int((atomic.AddUint64(&p.counter, 1) - 1) % uint64(len(p.upstreams)))
+	case "ip_hash":
+		h := fnv.New32a()
+		_, _ = h.Write(r.RemoteAddr.IP)
+		start = int(h.Sum32() % uint32(len(p.upstreams)))
+	}
+	var candidates []*upstream
+	for i := range p.upstreams {
+		u := p.upstreams[(start+i)%len(p.upstreams)]
+		if u.healthy() {
+			candidates = append(candidates, u)
+		}
+	}
+	return candidates
+}
+
+// proxyRead downloads the file from u and sends it to the client.
+// Network errors are only returned if nothing was sent yet.
+func (p *Proxy) proxyRead(r *Request, u *upstream) error {
+	wt, err := u.client.Receive(r.Filename, "octet")
+	if err != nil {
+		return err
+	}
+	if it, ok := wt.(tftp.IncomingTransfer); ok {
+		if n, ok := it.Size(); ok {
+			r.SetSize(n)
+		}
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := wt.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	_, err = r.ReadFrom(pr)
+	pr.CloseWithError(err)
+	<-done
+	return transferError(err)
+}
+
+// proxyWrite receives the file from the client and uploads it to u.
+// Network errors are only returned if nothing was received yet.
+func (p *Proxy) proxyWrite(r *Request, u *upstream) error {
+	rf, err := u.client.Send(r.Filename, "octet")
+	if err != nil {
+		return err
+	}
+	if n, ok := r.Size(); ok {
+		if ot, ok := rf.(tftp.OutgoingTransfer); ok {
+			ot.SetSize(n)
+		}
+	}
+	pr, pw := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		_, err := r.WriteTo(pw)
+		pw.CloseWithError(err)
+	}()
+	_, err = rf.ReadFrom(pr)
+	pr.CloseWithError(err)
+	<-done
+	return transferError(err)
+}
+
+// transferError hides network errors that occur once a transfer is
+// underway, as failing over would require restarting the transfer.
+func transferError(err error) error {
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return fmt.Errorf("upstream transfer failed: %s", err.Error())
+	}
+	return err
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+//
+//	proxy [<upstreams...>] {
+//	    to            <upstreams...>
+//	    lb_policy     round_robin|first|ip_hash
+//	    timeout       <duration>
+//	    fail_duration <duration>
+//	}
+func (p *Proxy) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	p.Upstreams = append(p.Upstreams, d.RemainingArgs()...)
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "to":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			p.Upstreams = append(p.Upstreams, args...)
+			continue
+		case "lb_policy":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			p.LBPolicy = d.Val()
+		case "timeout", "fail_duration":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s duration: %v", name, err)
+			}
+			if name == "timeout" {
+				p.Timeout = caddy.Duration(dur)
+			} else {
+				p.FailDuration = caddy.Duration(dur)
+			}
+		default:
+			return d.Errf("unrecognized proxy option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Proxy)(nil)
+	_ MiddlewareHandler     = (*Proxy)(nil)
+	_ caddyfile.Unmarshaler = (*Proxy)(nil)
+)
@@ -0,0 +1,100 @@
+package internal
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/pin/tftp/v3"
+)
+
+// startUpstream runs a plain pin/tftp server on a loopback port that
+// serves files and stores uploads in memory, and returns its address.
+func startUpstream(t *testing.T, files map[string]string) string {
+	t.Helper()
+	var mu sync.Mutex
+	read := func(filename string, rf io.ReaderFrom) error {
+		mu.Lock()
+		data, ok := files[filename]
+		mu.Unlock()
+		if !ok {
+			return os.ErrNotExist
+		}
+		_, err := rf.ReadFrom(strings.NewReader(data))
+		return err
+	}
+	write := func(filename string, wt io.WriterTo) error {
+		var buf bytes.Buffer
+		if _, err := wt.WriteTo(&buf); err != nil {
+			return err
+		}
+		mu.Lock()
+		files[filename] = buf.String()
+		mu.Unlock()
+		return nil
+	}
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := tftp.NewServer(read, write)
+	s.SetTimeout(time.Second)
+	go func() { _ = s.Serve(conn) }()
+	t.Cleanup(s.Shutdown)
+	return conn.LocalAddr().String()
+}
+
+// unusedAddr returns a loopback address nothing listens on.
+func unusedAddr(t *testing.T) string {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func TestProxy(t *testing.T) {
+	large := strings.Repeat("0123456789", 1000)
+	files := map[string]string{"pxelinux.0": "pxelinux", "large.img": large}
+	upstream := startUpstream(t, files)
+	p := &Proxy{Upstreams: []string{unusedAddr(t), upstream}, LBPolicy: "first", Timeout: caddy.Duration(100 * time.Millisecond)}
+	addr := startServer(t, &Server{}, p)
+
+	// the first upstream is down, so the request fails over to the second
+	// once the retries of the proxy are used up
+	c := newClient(t, addr)
+	c.SetTimeout(10 * time.Second)
+	wt, err := c.Receive("pxelinux.0", "octet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := wt.WriteTo(&buf); err != nil || buf.String() != "pxelinux" {
+		t.Errorf("pxelinux.0: got %q, %v", buf.String(), err)
+	}
+	if p.upstreams[0].healthy() {
+		t.Error("the upstream that is down is still considered healthy")
+	}
+	if got, err := download(t, addr, "large.img"); err != nil || got != large {
+		t.Errorf("large.img: got %d bytes, %v", len(got), err)
+	}
+	// error responses of the upstream are passed on
+	_, err = download(t, addr, "missing")
+	wantCode(t, err, errCodeFileNotFound)
+
+	if err := upload(t, addr, "up.txt", large); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := download(t, addr, "up.txt"); err != nil || got != large {
+		t.Errorf("up.txt: got %d bytes, %v", len(got), err)
+	}
+}
@@ -0,0 +1,213 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.uber.org/zap"
+)
+
+// errQuotaExceeded is returned for uploads beyond the quota of a server.
+var errQuotaExceeded = errors.New("upload quota exceeded")
+
+// Quota limits the total size and number of the uploads to a server,
+// per client, per root and across all clients. Usage counts every completed
+// upload and is kept in a state file, so it survives restarts; deleting
+// files does not reduce it, deleting the state file resets it.
+type Quota struct {
+	// The maximum total size of the uploads of a single client in bytes.
+	// Default is unlimited.
+	MaxBytesPerClient int64 `json:"max_bytes_per_client,omitempty"`
+
+	// The maximum number of uploads of a single client.
+	// Default is unlimited.
+	MaxFilesPerClient int64 `json:"max_files_per_client,omitempty"`
+
+	// The maximum total size of the uploads to a single root in bytes,
+	// which differs between clients with client_root. Default is unlimited.
+	MaxBytesPerRoot int64 `json:"max_bytes_per_root,omitempty"`
+
+	// The maximum number of uploads to a single root.
+	// Default is unlimited.
+	MaxFilesPerRoot int64 `json:"max_files_per_root,omitempty"`
+
+	// The maximum total size of the uploads of all clients in bytes.
+	// Default is unlimited.
+	MaxBytes int64 `json:"max_bytes,omitempty"`
+
+	// The maximum number of uploads of all clients.
+	// Default is unlimited.
+	MaxFiles int64 `json:"max_files,omitempty"`
+
+	// The file to keep the usage in. Default is tftp/quota-<server>.json
+	// in Caddy's data directory. Servers with the same state file share
+	// the usage.
+	StateFile string `json:"state_file,omitempty"`
+}
+
+// quotaUsage is the usage of a client, of a root or of all clients.
+type quotaUsage struct {
+	Bytes int64 `json:"bytes"`
+	Files int64 `json:"files"`
+}
+
+// quotaState is the content of a quota state file.
+type quotaState struct {
+	Total   quotaUsage             `json:"total"`
+	Clients map[string]*quotaUsage `json:"clients"`
+	Roots   map[string]*quotaUsage `json:"roots,omitempty"`
+}
+
+// quotaFiles shares the usage of each state file between the configs
+// using it, so uploads still draining from the old config after a reload
+// and those of the new one add up instead of overwriting each other.
+var quotaFiles = caddy.NewUsagePool()
+
+// quotaFile is the usage kept in a state file.
+type quotaFile struct {
+	path string
+
+	mu    sync.Mutex
+	state quotaState
+}
+
+// loadQuotaFile reads the usage from the state file at path.
+func loadQuotaFile(path string) (*quotaFile, error) {
+	qf := &quotaFile{
+		path:  path,
+		state: quotaState{Clients: make(map[string]*quotaUsage), Roots: make(map[string]*quotaUsage)},
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return qf, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &qf.state); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if qf.state.Clients == nil {
+		qf.state.Clients = make(map[string]*quotaUsage)
+	}
+	// state files written before per-root quotas have no roots
+	if qf.state.Roots == nil {
+		qf.state.Roots = make(map[string]*quotaUsage)
+	}
+	return qf, nil
+}
+
+// Destruct implements caddy.Destructor.
+func (*quotaFile) Destruct() error {
+	return nil
+}
+
+// quotaTracker enforces the quota of a server and records its usage.
+type quotaTracker struct {
+	quota *Quota
+	file  *quotaFile
+	log   *zap.Logger
+}
+
+// newQuotaTracker loads the usage of the server from the state file,
+// unless another config already did and still uses it.
+func newQuotaTracker(ctx caddy.Context, q *Quota, server string, log *zap.Logger) (*quotaTracker, error) {
+	path := q.StateFile
+	if path == "" {
+		path = filepath.Join(caddy.AppDataDir(), "tftp", "quota-"+server+".json")
+	}
+	path = filepath.Clean(path)
+	val, _, err := quotaFiles.LoadOrNew(path, func() (caddy.Destructor, error) {
+		return loadQuotaFile(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	// the config is unloaded once its transfers drained
+	ctx.OnCancel(func() { _, _ = quotaFiles.Delete(path) })
+	return &quotaTracker{quota: q, file: val.(*quotaFile), log: log}, nil
+}
+
+// remaining returns the number of bytes client may still upload to
+// root, or 0 if that is unlimited. It returns errQuotaExceeded if the
+// client may not upload at all. Uploads running at the same time may
+// together exceed the byte quota by up to the size of all but one of them.
+func (qt *quotaTracker) remaining(client, root string) (int64, error) {
+	qt.file.mu.Lock()
+	defer qt.file.mu.Unlock()
+	q, state := qt.quota, &qt.file.state
+	var left int64
+	for _, limit := range []struct {
+		usage              *quotaUsage
+		maxBytes, maxFiles int64
+	}{
+		{state.Clients[client], q.MaxBytesPerClient, q.MaxFilesPerClient},
+		{state.Roots[root], q.MaxBytesPerRoot, q.MaxFilesPerRoot},
+		{&state.Total, q.MaxBytes, q.MaxFiles},
+	} {
+		usage := limit.usage
+		if usage == nil {
+			usage = new(quotaUsage)
+		}
+		if exceeded(usage.Files, limit.maxFiles) || exceeded(usage.Bytes, limit.maxBytes) {
+			return 0, errQuotaExceeded
+		}
+		if limit.maxBytes > 0 && (left == 0 || limit.maxBytes-usage.Bytes < left) {
+			left = limit.maxBytes - usage.Bytes
+		}
+	}
+	return left, nil
+}
+
+// exceeded reports whether used reaches limit, if there is one.
+func exceeded(used, limit int64) bool {
+	return limit > 0 && used >= limit
+}
+
+// record adds an upload of n bytes by client to root to the usage
+// and writes it to the state file.
+func (qt *quotaTracker) record(client, root string, n int64) {
+	qt.file.mu.Lock()
+	defer qt.file.mu.Unlock()
+	addUsage(qt.file.state.Clients, client, n)
+	addUsage(qt.file.state.Roots, root, n)
+	qt.file.state.Total.Bytes += n
+	qt.file.state.Total.Files++
+	if err := qt.file.save(); err != nil {
+		qt.log.Error("writing quota state", zap.String("path", qt.file.path), zap.Error(err))
+	}
+}
+
+// addUsage adds an upload of n bytes to the usage of key in usages.
+func addUsage(usages map[string]*quotaUsage, key string, n int64) {
+	usage := usages[key]
+	if usage == nil {
+		usage = new(quotaUsage)
+		usages[key] = usage
+	}
+	usage.Bytes += n
+	usage.Files++
+}
+
+// save writes the usage to the state file, replacing it atomically.
+// The caller must hold the lock.
+func (qf *quotaFile) save() error {
+	data, err := json.Marshal(qf.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(qf.path), 0o700); err != nil {
+		return err
+	}
+	tmp := qf.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, qf.path)
+}
@@ -0,0 +1,76 @@
+package internal
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestQuotaRemaining(t *testing.T) {
+	qf, err := loadQuotaFile(filepath.Join(t.TempDir(), "quota.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	qt := &quotaTracker{
+		quota: &Quota{MaxBytesPerClient: 100, MaxBytesPerRoot: 150, MaxFilesPerRoot: 3, MaxBytes: 1000},
+		file:  qf,
+		log:   zap.NewNop(),
+	}
+	qt.record("192.0.2.1", "/srv/a", 60)
+	qt.record("192.0.2.2", "/srv/a", 60)
+	for _, tc := range []struct {
+		client, root string
+		want         int64
+		err          error
+	}{
+		{"192.0.2.1", "/srv/a", 30, nil},
+		{"192.0.2.1", "/srv/b", 40, nil},
+		{"192.0.2.3", "/srv/a", 30, nil},
+		{"192.0.2.3", "/srv/b", 100, nil},
+	} {
+		got, err := qt.remaining(tc.client, tc.root)
+		if got != tc.want || !errors.Is(err, tc.err) {
+			t.Errorf("remaining(%s, %s) = %d, %v, want %d, %v", tc.client, tc.root, got, err, tc.want, tc.err)
+		}
+	}
+	qt.record("192.0.2.3", "/srv/a", 1)
+	if _, err := qt.remaining("192.0.2.4", "/srv/a"); !errors.Is(err, errQuotaExceeded) {
+		t.Errorf("remaining in a full root: %v, want %v", err, errQuotaExceeded)
+	}
+	if _, err := qt.remaining("192.0.2.4", "/srv/b"); err != nil {
+		t.Errorf("remaining in another root: %v", err)
+	}
+}
+
+func TestQuota(t *testing.T) {
+	root := t.TempDir()
+	stateFile := filepath.Join(t.TempDir(), "quota.json")
+	addr := startServer(t, &Server{Root: root, Quota: &Quota{MaxFilesPerRoot: 2, StateFile: stateFile}}, &FileServer{})
+
+	for i := range 2 {
+		if err := upload(t, addr, "up"+strconv.Itoa(i), "data"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	wantCode(t, upload(t, addr, "up2", "data"), errCodeDiskFull)
+
+	data, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var state quotaState
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	if usage := state.Roots[root]; usage == nil || *usage != (quotaUsage{Bytes: 8, Files: 2}) {
+		t.Errorf("usage of the root = %+v, want 8 bytes in 2 files", usage)
+	}
+	if usage := state.Clients["127.0.0.1"]; usage == nil || usage.Files != 2 {
+		t.Errorf("usage of the client = %+v, want 2 files", usage)
+	}
+}
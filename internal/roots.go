@@ -0,0 +1,55 @@
+package internal
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"path/filepath"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+// ClientRoot is a root for the clients in some address ranges,
+// e.g. to serve every site of a network from its own directory.
+type ClientRoot struct {
+	// IP addresses or CIDR ranges of the clients.
+	Clients []string `json:"clients,omitempty"`
+
+	// The path to the root for these clients. Placeholders are
+	// supported like in the root of the server.
+	Root string `json:"root,omitempty"`
+}
+
+// clientRoot is a provisioned ClientRoot.
+type clientRoot struct {
+	clients []netip.Prefix
+	root    string
+}
+
+// provision parses the address ranges and makes the root absolute.
+func (cr ClientRoot) provision(repl *caddy.Replacer) (clientRoot, error) {
+	if len(cr.Clients) == 0 || cr.Root == "" {
+		return clientRoot{}, fmt.Errorf("clients and root are required")
+	}
+	clients, err := parsePrefixes(cr.Clients)
+	if err != nil {
+		return clientRoot{}, fmt.Errorf("clients: %v", err)
+	}
+	// request placeholders in the root are replaced per request
+	root, err := filepath.Abs(repl.ReplaceKnown(cr.Root, ""))
+	if err != nil {
+		return clientRoot{}, err
+	}
+	return clientRoot{clients: clients, root: root}, nil
+}
+
+// rootFor returns the root of the first client root that includes ip,
+// or the root of the server.
+func (s *tftpServer) rootFor(ip net.IP) string {
+	for _, cr := range s.clientRoots {
+		if allowedBy(ip, cr.clients, nil) {
+			return cr.root
+		}
+	}
+	return s.root
+}
@@ -0,0 +1,146 @@
+package internal
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(Route{})
+}
+
+// Route passes the requests that match its matchers through its own
+// handlers before the next handlers, e.g. to render only *.ipxe files
+// as templates or to proxy only images/*. Other requests skip them.
+type Route struct {
+	// Sets of matchers in the tftp.matchers namespace. A request matches
+	// if all matchers of any set match it. Default is all requests.
+	MatcherSetsRaw []caddy.ModuleMap `json:"match,omitempty" caddy:"namespace=tftp.matchers"`
+
+	// The handlers of matching requests, in the tftp.handlers namespace.
+	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=tftp.handlers inline_key=handler"`
+
+	matcherSets MatcherSets
+	handlers    []MiddlewareHandler
+}
+
+// CaddyModule returns the Caddy module information.
+func (Route) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.route",
+		New: func() caddy.Module { return new(Route) },
+	}
+}
+
+// Provision loads the matchers and handlers.
+func (rt *Route) Provision(ctx caddy.Context) error {
+	if rt.MatcherSetsRaw != nil {
+		mods, err := ctx.LoadModule(rt, "MatcherSetsRaw")
+		if err != nil {
+			return fmt.Errorf("loading matcher modules: %v", err)
+		}
+		for _, set := range mods.([]map[string]any) {
+			var ms MatcherSet
+			for _, m := range set {
+				ms = append(ms, m.(RequestMatcher))
+			}
+			rt.matcherSets = append(rt.matcherSets, ms)
+		}
+	}
+	if rt.HandlersRaw != nil {
+		mods, err := ctx.LoadModule(rt, "HandlersRaw")
+		if err != nil {
+			return fmt.Errorf("loading handler modules: %v", err)
+		}
+		for _, mod := range mods.([]any) {
+			rt.handlers = append(rt.handlers, mod.(MiddlewareHandler))
+		}
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (rt *Route) ServeTFTP(r *Request, next Handler) error {
+	if !rt.matcherSets.AnyMatch(r) {
+		return next.ServeTFTP(r)
+	}
+	return compileHandlers(rt.handlers, next).ServeTFTP(r)
+}
+
+// UnmarshalCaddyfile sets up the route from Caddyfile tokens.
+// Each match block is a matcher set.
+//
+//	route {
+//	    match {
+//	        file        <patterns...>
+//	        file_regexp <pattern>
+//	        remote_ip   <cidrs...>
+//	        mode        octet|netascii...
+//	        method      read|write...
+//	    }
+//
+//	    <handler> [<args...>] {
+//	        ...
+//	    }
+//	}
+func (rt *Route) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for nesting := d.Nesting(); d.NextBlock(nesting); {
+		if d.Val() == "match" {
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+			matchers := make(map[string]caddyfile.Unmarshaler)
+			for nesting := d.Nesting(); d.NextBlock(nesting); {
+				name := d.Val()
+				unm, ok := matchers[name]
+				if !ok {
+					mod, err := caddy.GetModule("tftp.matchers." + name)
+					if err != nil {
+						return d.Errf("unrecognized matcher '%s'", name)
+					}
+					unm, ok = mod.New().(caddyfile.Unmarshaler)
+					if !ok {
+						return d.Errf("matcher '%s' does not support the Caddyfile", name)
+					}
+					matchers[name] = unm
+				}
+				// repeated matchers of a set add to the same one
+				if err := unm.UnmarshalCaddyfile(d.NewFromNextSegment()); err != nil {
+					return err
+				}
+			}
+			set := make(caddy.ModuleMap)
+			for name, unm := range matchers {
+				set[name] = caddyconfig.JSON(unm, nil)
+			}
+			rt.MatcherSetsRaw = append(rt.MatcherSetsRaw, set)
+			continue
+		}
+		handlerName := d.Val()
+		modID := "tftp.handlers." + handlerName
+		if _, err := caddy.GetModule(modID); err != nil {
+			return d.Errf("unrecognized handler '%s'", handlerName)
+		}
+		unm, err := caddyfile.UnmarshalModule(d, modID)
+		if err != nil {
+			return err
+		}
+		rt.HandlersRaw = append(rt.HandlersRaw, caddyconfig.JSONModuleObject(unm, "handler", handlerName, nil))
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Route)(nil)
+	_ MiddlewareHandler     = (*Route)(nil)
+	_ caddyfile.Unmarshaler = (*Route)(nil)
+)
@@ -0,0 +1,460 @@
+package internal
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(S3{})
+}
+
+// S3 serves downloads from a bucket of an S3-compatible object storage,
+// optionally keeping the fetched objects in a local cache directory.
+// Uploads are passed on to the next handler.
+type S3 struct {
+	// The bucket to fetch objects from.
+	Bucket string `json:"bucket,omitempty"`
+
+	// The prefix prepended to the requested file name to form the key
+	// of the object, e.g. "boot/".
+	Prefix string `json:"prefix,omitempty"`
+
+	// The URL of the object storage.
+	// Default is https://s3.<region>.amazonaws.com.
+	Endpoint string `json:"endpoint,omitempty"`
+
+	// The region of the bucket. Default is us-east-1.
+	Region string `json:"region,omitempty"`
+
+	// Address the bucket in the path of the URL instead of the host name,
+	// as most self-hosted object storages require.
+	PathStyle bool `json:"path_style,omitempty"`
+
+	// The credentials to sign requests with; placeholders are supported.
+	// Default is the AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY and
+	// AWS_SESSION_TOKEN environment variables. Without credentials,
+	// requests are sent unsigned.
+	AccessKeyID     string `json:"access_key_id,omitempty"`
+	SecretAccessKey string `json:"secret_access_key,omitempty"`
+	SessionToken    string `json:"session_token,omitempty"`
+
+	// The maximum time to wait for the object storage to respond.
+	// Default is 30 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	// A directory to keep fetched objects in. Cached objects are
+	// revalidated with their ETag, and served as they are while the
+	// object storage is unreachable.
+	CacheDir string `json:"cache_dir,omitempty"`
+
+	// How long cached objects are served without revalidating them.
+	// Default is 0, which revalidates them on every download.
+	CacheTTL caddy.Duration `json:"cache_ttl,omitempty"`
+
+	endpoint *url.URL
+	client   *http.Client
+	log      *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (S3) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.s3",
+		New: func() caddy.Module { return new(S3) },
+	}
+}
+
+// Provision sets up the HTTP client and resolves the credentials.
+func (s *S3) Provision(ctx caddy.Context) error {
+	s.log = ctx.Logger()
+	if s.Bucket == "" {
+		return fmt.Errorf("bucket is required")
+	}
+	if s.Region == "" {
+		s.Region = "us-east-1"
+	}
+	endpoint := s.Endpoint
+	if endpoint == "" {
+		endpoint = "https://s3." + s.Region + ".amazonaws.com"
+	}
+	var err error
+	s.endpoint, err = url.Parse(endpoint)
+	if err != nil {
+		return fmt.Errorf("parsing endpoint: %v", err)
+	}
+	if s.endpoint.Scheme != "http" && s.endpoint.Scheme != "https" {
+		return fmt.Errorf("endpoint must be an http or https URL: %s", endpoint)
+	}
+
+	repl := caddy.NewReplacer()
+	s.AccessKeyID = repl.ReplaceKnown(s.AccessKeyID, "")
+	s.SecretAccessKey = repl.ReplaceKnown(s.SecretAccessKey, "")
+	s.SessionToken = repl.ReplaceKnown(s.SessionToken, "")
+	if s.AccessKeyID == "" && s.SecretAccessKey == "" {
+		s.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		s.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		s.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	if s.CacheDir != "" {
+		if err := os.MkdirAll(s.CacheDir, 0o755); err != nil {
+			return fmt.Errorf("creating cache directory: %v", err)
+		}
+	}
+
+	timeout := time.Duration(s.Timeout)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	// as with http_upstream, the timeout only covers waiting for the
+	// response headers, since the body is paced by the TFTP transfer
+	s.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:                 http.ProxyFromEnvironment,
+			DialContext:           (&net.Dialer{Timeout: timeout}).DialContext,
+			TLSHandshakeTimeout:   timeout,
+			ResponseHeaderTimeout: timeout,
+		},
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (s *S3) ServeTFTP(r *Request, next Handler) error {
+	if r.Method != MethodRead {
+		return next.ServeTFTP(r)
+	}
+	key := s.Prefix + strings.TrimPrefix(path.Clean("/"+r.Filename), "/")
+	if s.CacheDir == "" {
+		return s.fetch(r, key, nil)
+	}
+	return s.serveCached(r, key)
+}
+
+// serveCached serves the object key from the cache directory,
+// fetching or revalidating it as needed.
+func (s *S3) serveCached(r *Request, key string) error {
+	sum := sha256.Sum256([]byte(s.Bucket + "/" + key))
+	c := &s3CacheFile{name: filepath.Join(s.CacheDir, hex.EncodeToString(sum[:]))}
+	if info, err := os.Stat(c.name); err == nil {
+		c.info = info
+		if etag, err := os.ReadFile(c.name + ".etag"); err == nil {
+			c.etag = string(etag)
+		}
+		if time.Since(info.ModTime()) < time.Duration(s.CacheTTL) {
+			return c.serve(r)
+		}
+	}
+	err := s.fetch(r, key, c)
+	if err != nil && c.info != nil && !r.started && errorCode(err) == errCodeNotDefined {
+		s.log.Warn("serving cached object, fetching it failed",
+			zap.String("transfer_id", r.ID),
+			zap.String("key", key),
+			zap.Error(err))
+		return c.serve(r)
+	}
+	return err
+}
+
+// fetch sends the object key to the client. If c is not nil, a cached
+// copy of the object is revalidated, or the object is stored in c.
+func (s *S3) fetch(r *Request, key string, c *s3CacheFile) error {
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return err
+	}
+	if c != nil && c.etag != "" {
+		req.Header.Set("If-None-Match", c.etag)
+	}
+	s.sign(req, time.Now(), emptyPayloadHash)
+	s.log.Debug("fetching object",
+		zap.String("transfer_id", r.ID),
+		zap.String("bucket", s.Bucket),
+		zap.String("key", key))
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified && c != nil && c.info != nil:
+		now := time.Now()
+		_ = os.Chtimes(c.name, now, now)
+		return c.serve(r)
+	case resp.StatusCode == http.StatusNotFound:
+		return fs.ErrNotExist
+	case resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized:
+		return fs.ErrPermission
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		return fmt.Errorf("object storage responded with status %s", resp.Status)
+	}
+	if resp.ContentLength >= 0 {
+		r.SetSize(resp.ContentLength)
+	}
+	if c == nil {
+		_, err = r.ReadFrom(resp.Body)
+		return err
+	}
+
+	// store the object while sending it, keeping the previous copy
+	// unless the transfer completes
+	tmp, err := os.CreateTemp(s.CacheDir, ".fetch-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = r.ReadFrom(io.TeeReader(resp.Body, tmp))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), c.name); err != nil {
+		s.log.Error("caching object failed", zap.String("key", key), zap.Error(err))
+		return nil
+	}
+	if etag := resp.Header.Get("ETag"); etag != "" {
+		_ = os.WriteFile(c.name+".etag", []byte(etag), 0o644)
+	} else {
+		_ = os.Remove(c.name + ".etag")
+	}
+	return nil
+}
+
+// put stores body, which has size bytes and the SHA-256 hash
+// payloadHash, as the object key.
+func (s *S3) put(ctx context.Context, key string, body io.Reader, size int64, payloadHash string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), body)
+	if err != nil {
+		return err
+	}
+	req.ContentLength = size
+	s.sign(req, time.Now(), payloadHash)
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("object storage responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+// objectURL returns the URL of the object key.
+func (s *S3) objectURL(key string) string {
+	u := *s.endpoint
+	if s.PathStyle {
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + s.Bucket + "/" + key
+	} else {
+		u.Host = s.Bucket + "." + u.Host
+		u.Path = strings.TrimSuffix(u.Path, "/") + "/" + key
+	}
+	u.RawPath = s3EscapePath(u.Path)
+	return u.String()
+}
+
+// emptyPayloadHash is the SHA-256 hash of an empty request body.
+const emptyPayloadHash = "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"
+
+// sign signs req, whose body has the SHA-256 hash payloadHash, with
+// AWS Signature Version 4, unless no credentials are configured.
+func (s *S3) sign(req *http.Request, now time.Time, payloadHash string) {
+	if s.AccessKeyID == "" {
+		return
+	}
+	now = now.UTC()
+	amzDate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+
+	headers := "host:" + req.URL.Host + "\n" +
+		"x-amz-content-sha256:" + payloadHash + "\n" +
+		"x-amz-date:" + amzDate + "\n"
+	signedHeaders := "host;x-amz-content-sha256;x-amz-date"
+	if s.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.SessionToken)
+		headers += "x-amz-security-token:" + s.SessionToken + "\n"
+		signedHeaders += ";x-amz-security-token"
+	}
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"", // no query
+		headers,
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := date + "/" + s.Region + "/s3/aws4_request"
+	hash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := []byte("AWS4" + s.SecretAccessKey)
+	for _, part := range []string{date, s.Region, "s3", "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// s3EscapePath escapes p the way Signature Version 4 expects:
+// everything but unreserved characters and slashes.
+func s3EscapePath(p string) string {
+	var b strings.Builder
+	for i := 0; i < len(p); i++ {
+		c := p[i]
+		if 'A' <= c && c <= 'Z' || 'a' <= c && c <= 'z' || '0' <= c && c <= '9' ||
+			c == '-' || c == '.' || c == '_' || c == '~' || c == '/' {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
+
+// s3CacheFile is the cached copy of an object.
+type s3CacheFile struct {
+	name string
+	info fs.FileInfo // nil if not cached
+	etag string
+}
+
+func (c *s3CacheFile) serve(r *Request) error {
+	f, err := os.Open(c.name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	// a refresh may have renamed another version into place since the
+	// cache was checked, so the size is that of the opened file
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	r.SetSize(info.Size())
+	_, err = r.ReadFrom(f)
+	return err
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+//
+//	s3 <bucket> {
+//	    prefix            <prefix>
+//	    endpoint          <url>
+//	    region            <region>
+//	    path_style
+//	    access_key_id     <id>
+//	    secret_access_key <secret>
+//	    session_token     <token>
+//	    timeout           <duration>
+//	    cache_dir         <path>
+//	    cache_ttl         <duration>
+//	}
+func (s *S3) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	s.Bucket = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "prefix":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Prefix = d.Val()
+		case "endpoint":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Endpoint = d.Val()
+		case "region":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Region = d.Val()
+		case "access_key_id":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.AccessKeyID = d.Val()
+		case "secret_access_key":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.SecretAccessKey = d.Val()
+		case "session_token":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.SessionToken = d.Val()
+		case "cache_dir":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.CacheDir = d.Val()
+		case "path_style":
+			s.PathStyle = true
+		case "timeout", "cache_ttl":
+			name := d.Val()
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing %s duration: %v", name, err)
+			}
+			if name == "timeout" {
+				s.Timeout = caddy.Duration(dur)
+			} else {
+				s.CacheTTL = caddy.Duration(dur)
+			}
+		default:
+			return d.Errf("unrecognized s3 option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*S3)(nil)
+	_ MiddlewareHandler     = (*S3)(nil)
+	_ caddyfile.Unmarshaler = (*S3)(nil)
+)
@@ -0,0 +1,102 @@
+package internal
+
+import (
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// sharedFiles shares the open files of a file server between the
+// downloads of the same file at the same time, so a boot storm of
+// hundreds of clients pulling the same kernel opens and maps it once
+// instead of once per client. Each download reads the shared file at
+// its own offset, and the file is closed once the last one is done.
+type sharedFiles struct {
+	mu sync.Mutex
+	m  map[string]*sharedFile
+}
+
+// sharedFile is a file open for one or more downloads.
+type sharedFile struct {
+	key   string
+	info  fs.FileInfo
+	ready chan struct{} // closed once the file is open or failed to open
+	refs  int
+
+	// set once ready is closed
+	file *os.File
+	data []byte // the memory mapping of the file, if mapped
+	err  error
+}
+
+func newSharedFiles() *sharedFiles {
+	return &sharedFiles{m: make(map[string]*sharedFile)}
+}
+
+// acquire returns the file identified by key, which is described by
+// info, calling open if it is not open yet or changed since; open returns
+// the file, its info and its memory mapping, if mapped. Concurrent calls
+// for the same file share a single open. The file must be released once
+// the download is done.
+func (sf *sharedFiles) acquire(key string, info fs.FileInfo, open func() (*os.File, fs.FileInfo, []byte, error)) (*sharedFile, error) {
+	sf.mu.Lock()
+	if f, ok := sf.m[key]; ok && sameVersion(f.info, info) {
+		f.refs++
+		sf.mu.Unlock()
+		<-f.ready
+		if f.err != nil {
+			sf.release(f)
+			return nil, f.err
+		}
+		return f, nil
+	}
+	// downloads of a previous version keep it until they are done
+	f := &sharedFile{key: key, info: info, ready: make(chan struct{}), refs: 1}
+	sf.m[key] = f
+	sf.mu.Unlock()
+
+	var stat fs.FileInfo
+	f.file, stat, f.data, f.err = open()
+	sf.mu.Lock()
+	if f.err == nil {
+		// the file may have changed between the stat and the open
+		f.info = stat
+	} else if sf.m[key] == f {
+		delete(sf.m, key)
+	}
+	sf.mu.Unlock()
+	close(f.ready)
+	if f.err != nil {
+		sf.release(f)
+		return nil, f.err
+	}
+	return f, nil
+}
+
+// release gives up a file returned by acquire, closing it if it was the
+// last download using it.
+func (sf *sharedFiles) release(f *sharedFile) {
+	sf.mu.Lock()
+	f.refs--
+	last := f.refs == 0
+	if last && sf.m[f.key] == f {
+		delete(sf.m, f.key)
+	}
+	sf.mu.Unlock()
+	if !last {
+		return
+	}
+	if f.data != nil {
+		_ = munmap(f.data)
+	}
+	if f.file != nil {
+		_ = f.file.Close()
+	}
+}
+
+// sameVersion reports whether a and b describe the same version of the
+// same file: one replaced by a rename is another file, one modified in
+// place has another modification time or size.
+func sameVersion(a, b fs.FileInfo) bool {
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
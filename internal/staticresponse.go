@@ -0,0 +1,97 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(StaticResponse{})
+}
+
+// StaticResponse sends content from the config for downloads of matching
+// file names, without touching the filesystem, e.g. a short iPXE script
+// that chainloads the real one over HTTP. Uploads and downloads of other
+// names are passed to the next handlers.
+type StaticResponse struct {
+	// Glob patterns of the file names to respond to, e.g. "boot.ipxe".
+	// Default is all files.
+	MatchFiles []string `json:"match,omitempty"`
+
+	// The content to send. Placeholders are supported; unknown ones are
+	// sent as they are, so the ${...} variables of iPXE scripts survive.
+	Body string `json:"body,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (StaticResponse) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.static_response",
+		New: func() caddy.Module { return new(StaticResponse) },
+	}
+}
+
+// Provision validates the configuration.
+func (s *StaticResponse) Provision(_ caddy.Context) error {
+	for _, pattern := range s.MatchFiles {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (s *StaticResponse) ServeTFTP(r *Request, next Handler) error {
+	if r.Method != MethodRead || (len(s.MatchFiles) > 0 && !matchPath(s.MatchFiles, r.Filename)) {
+		return next.ServeTFTP(r)
+	}
+	body := r.Replacer().ReplaceKnown(s.Body, "")
+	r.SetSize(int64(len(body)))
+	_, err := r.ReadFrom(strings.NewReader(body))
+	return err
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+// A body spanning several lines can be given as a heredoc.
+//
+//	static_response [<patterns...>] {
+//	    match <patterns...>
+//	    body  <text>
+//	}
+func (s *StaticResponse) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	s.MatchFiles = append(s.MatchFiles, d.RemainingArgs()...)
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "match":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			s.MatchFiles = append(s.MatchFiles, args...)
+		case "body":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			s.Body = d.Val()
+			if d.NextArg() {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unrecognized static_response option '%s'", d.Val())
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*StaticResponse)(nil)
+	_ MiddlewareHandler     = (*StaticResponse)(nil)
+	_ caddyfile.Unmarshaler = (*StaticResponse)(nil)
+)
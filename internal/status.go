@@ -0,0 +1,111 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// serverStats counts the transfers of a server since it started.
+type serverStats struct {
+	started       time.Time
+	transfers     atomic.Int64
+	failed        atomic.Int64
+	bytesSent     atomic.Int64
+	bytesReceived atomic.Int64
+
+	mu      sync.Mutex
+	lastErr *lastError
+}
+
+// lastError describes the most recent failed transfer of a server.
+type lastError struct {
+	Time       time.Time `json:"time"`
+	Error      string    `json:"error"`
+	Method     string    `json:"method"`
+	Filename   string    `json:"filename"`
+	RemoteAddr string    `json:"remote_addr"`
+}
+
+// serverStatus describes a server in the admin API.
+type serverStatus struct {
+	Name          string     `json:"name"`
+	Listen        []string   `json:"listen"`
+	Started       time.Time  `json:"started"`
+	Uptime        string     `json:"uptime"`
+	Transfers     int64      `json:"transfers"`
+	Failed        int64      `json:"failed"`
+	InFlight      int        `json:"in_flight"`
+	Draining      bool       `json:"draining"`
+	BytesSent     int64      `json:"bytes_sent"`
+	BytesReceived int64      `json:"bytes_received"`
+	LastError     *lastError `json:"last_error,omitempty"`
+}
+
+// record counts the finished request r, which failed if err is not nil.
+func (st *serverStats) record(r *Request, filename string, err error) {
+	st.transfers.Add(1)
+	if r.Method == MethodRead {
+		st.bytesSent.Add(r.Bytes())
+	} else {
+		st.bytesReceived.Add(r.Bytes())
+	}
+	if err == nil {
+		return
+	}
+	st.failed.Add(1)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.lastErr = &lastError{
+		Time:       time.Now(),
+		Error:      err.Error(),
+		Method:     r.Method,
+		Filename:   filename,
+		RemoteAddr: r.RemoteAddr.String(),
+	}
+}
+
+// status returns the status of the server.
+func (s *tftpServer) status() serverStatus {
+	st := serverStatus{
+		Name:          s.name,
+		Listen:        []string{},
+		Started:       s.stats.started,
+		Transfers:     s.stats.transfers.Load(),
+		Failed:        s.stats.failed.Load(),
+		InFlight:      s.transfers.count(),
+		Draining:      s.draining.Load(),
+		BytesSent:     s.stats.bytesSent.Load(),
+		BytesReceived: s.stats.bytesReceived.Load(),
+	}
+	if !st.Started.IsZero() {
+		st.Uptime = time.Since(st.Started).Round(time.Second).String()
+	}
+	for _, sl := range s.listeners {
+		if sl.ln != nil {
+			st.Listen = append(st.Listen, sl.ln.LocalAddr().String())
+		}
+	}
+	s.stats.mu.Lock()
+	st.LastError = s.stats.lastErr
+	s.stats.mu.Unlock()
+	return st
+}
+
+// isHealthFile reports whether r requests the health_file of the server.
+func (s *tftpServer) isHealthFile(r *Request) bool {
+	return s.healthFile != "" && strings.TrimPrefix(r.Filename, "/") == s.healthFile
+}
+
+// serveHealth answers a health check, proving the server is serving.
+// The health file cannot be uploaded.
+func serveHealth(r *Request) error {
+	if r.Method != MethodRead {
+		return errAccessViolation
+	}
+	const ok = "OK\n"
+	r.SetSize(int64(len(ok)))
+	_, err := r.ReadFrom(strings.NewReader(ok))
+	return err
+}
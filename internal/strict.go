@@ -0,0 +1,60 @@
+package internal
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// errUnknownMode is returned in strict mode for requests with a
+// transfer mode other than netascii and octet.
+var errUnknownMode = errors.New("unknown transfer mode")
+
+// errMailMode is returned in strict mode for requests in the obsolete
+// mail mode of RFC 1350.
+var errMailMode = errors.New("mail mode is not supported")
+
+// errInvalidOption is returned for requests with an option value outside
+// the range its RFC allows in strict mode, or below min_block_size.
+var errInvalidOption = errors.New("invalid option value")
+
+// strictRequest checks r against RFC 1350 and the option extensions of
+// RFC 2347, 2348, 2349 and 7440.
+func strictRequest(r *Request) error {
+	var transfer any = r.rf
+	if r.Method == MethodWrite {
+		transfer = r.wt
+	}
+	switch strings.ToLower(rawTransferMode(transfer)) {
+	case "octet", "netascii":
+	case "mail":
+		return errMailMode
+	default:
+		return errUnknownMode
+	}
+	for name, value := range r.requested {
+		min, max := int64(0), int64(-1)
+		switch strings.ToLower(name) {
+		case "blksize":
+			min, max = 8, 65464
+		case "timeout":
+			min, max = 1, 255
+		case "windowsize":
+			min, max = 1, 65535
+		case "tsize":
+			// a client reading a file does not know its size yet
+			if r.Method == MethodRead && value != "0" {
+				return fmt.Errorf("%w: %s=%s", errInvalidOption, name, value)
+			}
+		default:
+			// unknown options are ignored
+			continue
+		}
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil || n < min || (max >= 0 && n > max) {
+			return fmt.Errorf("%w: %s=%s", errInvalidOption, name, value)
+		}
+	}
+	return nil
+}
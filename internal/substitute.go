@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(Substitute{})
+}
+
+// Substitute replaces tokens in the files sent by the next handlers,
+// e.g. to tweak kickstart, preseed or autoexec files per client without
+// a template engine. Unknown tokens are sent as they are. The tokens are:
+//
+//	{{client_ip}}    the IP address of the client
+//	{{client_port}}  the port of the client
+//	{{server_ip}}    the IP address the request was received on
+//	{{filename}}     the requested file name
+//	{{env.<name>}}   the value of an environment variable
+//	{{<var>}}        the value of one of the vars
+//
+// The files are buffered in memory, so the tsize option reports the size
+// after substitution.
+type Substitute struct {
+	// Glob patterns of the file names to substitute tokens in, e.g.
+	// "*.ks" or "preseed/*". Default is all files.
+	MatchFiles []string `json:"match,omitempty"`
+
+	// Additional tokens and their values, which support placeholders.
+	Vars map[string]string `json:"vars,omitempty"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Substitute) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.substitute",
+		New: func() caddy.Module { return new(Substitute) },
+	}
+}
+
+// Provision validates the configuration.
+func (s *Substitute) Provision(_ caddy.Context) error {
+	for _, pattern := range s.MatchFiles {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (s *Substitute) ServeTFTP(r *Request, next Handler) error {
+	if r.Method != MethodRead || (len(s.MatchFiles) > 0 && !matchPath(s.MatchFiles, r.Filename)) {
+		return next.ServeTFTP(r)
+	}
+
+	var buf bytes.Buffer
+	if err := next.ServeTFTP(r.capture(bufferReaderFrom{&buf})); err != nil {
+		return err
+	}
+
+	out := substituteTokens(buf.Bytes(), func(name string) (string, bool) {
+		return s.lookup(r, name)
+	})
+	r.SetSize(int64(len(out)))
+	_, err := r.ReadFrom(bytes.NewReader(out))
+	return err
+}
+
+// lookup returns the value of the token name for r.
+func (s *Substitute) lookup(r *Request, name string) (string, bool) {
+	switch name {
+	case "client_ip":
+		return r.RemoteAddr.IP.String(), true
+	case "client_port":
+		return strconv.Itoa(r.RemoteAddr.Port), true
+	case "server_ip":
+		if r.LocalIP == nil {
+			return "", true
+		}
+		return r.LocalIP.String(), true
+	case "filename":
+		return r.Filename, true
+	}
+	if env, ok := strings.CutPrefix(name, "env."); ok {
+		return os.Getenv(env), true
+	}
+	if value, ok := s.Vars[name]; ok {
+		return r.Replacer().ReplaceAll(value, ""), true
+	}
+	return "", false
+}
+
+// substituteTokens replaces every {{name}} in content for which lookup
+// returns a value.
+func substituteTokens(content []byte, lookup func(name string) (string, bool)) []byte {
+	out := make([]byte, 0, len(content))
+	for {
+		start := bytes.Index(content, []byte("{{"))
+		if start < 0 {
+			break
+		}
+		end := bytes.Index(content[start+2:], []byte("}}"))
+		if end < 0 {
+			break
+		}
+		name := strings.TrimSpace(string(content[start+2 : start+2+end]))
+		value, ok := lookup(name)
+		if !ok {
+			out = append(out, content[:start+2]...)
+			content = content[start+2:]
+			continue
+		}
+		out = append(out, content[:start]...)
+		out = append(out, value...)
+		content = content[start+2+end+2:]
+	}
+	return append(out, content...)
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+//
+//	substitute [<patterns...>] {
+//	    match <patterns...>
+//	    var   <name> <value>
+//	}
+func (s *Substitute) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	s.MatchFiles = append(s.MatchFiles, d.RemainingArgs()...)
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "match":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			s.MatchFiles = append(s.MatchFiles, args...)
+		case "var":
+			args := d.RemainingArgs()
+			if len(args) != 2 {
+				return d.ArgErr()
+			}
+			if s.Vars == nil {
+				s.Vars = make(map[string]string)
+			}
+			s.Vars[args[0]] = args[1]
+		default:
+			return d.Errf("unrecognized substitute option '%s'", d.Val())
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Substitute)(nil)
+	_ MiddlewareHandler     = (*Substitute)(nil)
+	_ caddyfile.Unmarshaler = (*Substitute)(nil)
+)
@@ -0,0 +1,61 @@
+package internal
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// errSymlink is returned for paths through symlinks
+// when the symlinks policy is deny.
+var errSymlink = errors.New("path contains a symlink")
+
+// localFS returns the file system to serve downloads from below root,
+// according to the symlinks policy of r.
+func localFS(r *Request, rt *os.Root, root string) fs.FS {
+	switch r.Symlinks {
+	case "follow":
+		return os.DirFS(root)
+	case "deny":
+		return noSymlinksFS{FS: rt.FS(), root: rt}
+	default:
+		return rt.FS()
+	}
+}
+
+// noSymlinksFS is a file system that refuses to open paths through
+// symlinks. Since it is backed by an os.Root, a symlink swapped in
+// after the check still cannot lead out of the root.
+type noSymlinksFS struct {
+	fs.FS
+	root *os.Root
+}
+
+func (f noSymlinksFS) Open(name string) (fs.File, error) {
+	if err := checkNoSymlinks(f.root, name); err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return f.FS.Open(name)
+}
+
+// checkNoSymlinks returns an error if any element of the slash-separated
+// name in rt is a symlink.
+func checkNoSymlinks(rt *os.Root, name string) error {
+	if name == "." {
+		return nil
+	}
+	var p string
+	for _, elem := range strings.Split(name, "/") {
+		p = filepath.Join(p, elem)
+		info, err := rt.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if info.Mode()&fs.ModeSymlink != 0 {
+			return errSymlink
+		}
+	}
+	return nil
+}
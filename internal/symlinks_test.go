@@ -0,0 +1,73 @@
+package internal
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSymlinks(t *testing.T) {
+	outside := t.TempDir()
+	if err := os.WriteFile(filepath.Join(outside, "secret"), []byte("secret"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	root := t.TempDir()
+	for _, err := range []error{
+		os.WriteFile(filepath.Join(root, "in.txt"), []byte("in"), 0o644),
+		os.Mkdir(filepath.Join(root, "sub"), 0o755),
+		os.Symlink("in.txt", filepath.Join(root, "link_in")),
+		os.Symlink(filepath.Join(outside, "secret"), filepath.Join(root, "link_out")),
+		os.Symlink("sub", filepath.Join(root, "dir_in")),
+		os.Symlink(outside, filepath.Join(root, "dir_out")),
+	} {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		policy  string
+		linkIn  bool
+		linkOut bool
+		dirIn   bool
+	}{
+		{policy: "follow", linkIn: true, linkOut: true, dirIn: true},
+		{policy: "deny_escape", linkIn: true, dirIn: true},
+		{policy: "deny"},
+	} {
+		t.Run(tc.policy, func(t *testing.T) {
+			addr := startServer(t, &Server{Root: root, Symlinks: tc.policy, Overwrite: "allow"}, &FileServer{})
+
+			got, err := download(t, addr, "link_in")
+			if tc.linkIn && (err != nil || got != "in") {
+				t.Errorf("link_in: got %q, %v", got, err)
+			} else if !tc.linkIn {
+				wantCode(t, err, errCodeAccessViolation)
+			}
+			got, err = download(t, addr, "link_out")
+			if tc.linkOut && (err != nil || got != "secret") {
+				t.Errorf("link_out: got %q, %v", got, err)
+			} else if !tc.linkOut && err == nil {
+				t.Errorf("link_out: got %q, want an error", got)
+			}
+
+			err = upload(t, addr, "dir_in/up.txt", tc.policy)
+			if tc.dirIn {
+				if err != nil {
+					t.Errorf("dir_in/up.txt: %v", err)
+				} else if data, err := os.ReadFile(filepath.Join(root, "sub", "up.txt")); err != nil || string(data) != tc.policy {
+					t.Errorf("dir_in/up.txt: stored %q, %v", data, err)
+				}
+			} else {
+				wantCode(t, err, errCodeAccessViolation)
+			}
+			// uploads never follow symlinks out of the root
+			if err := upload(t, addr, "dir_out/up.txt", tc.policy); err == nil {
+				t.Error("dir_out/up.txt: succeeded")
+			}
+			if _, err := os.Stat(filepath.Join(outside, "up.txt")); err == nil {
+				t.Error("dir_out/up.txt: stored outside the root")
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package internal
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"text/template"
+
+	"github.com/Masterminds/sprig/v3"
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(Templates{})
+}
+
+// Templates renders the files sent by the next handlers as Go templates,
+// e.g. to generate per-host iPXE scripts or PXELINUX menus.
+//
+// Besides the sprig functions, templates have access to:
+//
+//	{{.Filename}}                 the requested file name
+//	{{.RemoteIP}}                 the IP address of the client
+//	{{.RemotePort}}               the port of the client
+//	{{placeholder "<name>"}}      the value of a placeholder
+//	{{env "<name>"}}              the value of an environment variable
+type Templates struct {
+	// Glob patterns of the file names to render, e.g. "*.ipxe"
+	// or "pxelinux.cfg/*". Default is all files.
+	MatchFiles []string `json:"match,omitempty"`
+
+	// The template action delimiters. Default is ["{{", "}}"].
+	Delimiters []string `json:"delimiters,omitempty"`
+}
+
+// templateContext is the data a template is executed with.
+type templateContext struct {
+	Filename   string
+	RemoteIP   string
+	RemotePort int
+}
+
+// CaddyModule returns the Caddy module information.
+func (Templates) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.templates",
+		New: func() caddy.Module { return new(Templates) },
+	}
+}
+
+// Provision validates the configuration.
+func (t *Templates) Provision(_ caddy.Context) error {
+	for _, pattern := range t.MatchFiles {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	if len(t.Delimiters) != 0 && len(t.Delimiters) != 2 {
+		return fmt.Errorf("delimiters must consist of exactly two elements: opening and closing")
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (t *Templates) ServeTFTP(r *Request, next Handler) error {
+	if r.Method != MethodRead || (len(t.MatchFiles) > 0 && !matchPath(t.MatchFiles, r.Filename)) {
+		return next.ServeTFTP(r)
+	}
+
+	var buf bytes.Buffer
+	if err := next.ServeTFTP(r.capture(bufferReaderFrom{&buf})); err != nil {
+		return err
+	}
+
+	repl := r.Replacer()
+	tpl := template.New(r.Filename).Funcs(sprig.TxtFuncMap()).Funcs(template.FuncMap{
+		"placeholder": func(name string) string {
+			return repl.ReplaceAll("{"+name+"}", "")
+		},
+	})
+	if len(t.Delimiters) == 2 {
+		tpl.Delims(t.Delimiters[0], t.Delimiters[1])
+	}
+	if _, err := tpl.Parse(buf.String()); err != nil {
+		return fmt.Errorf("parsing template %s: %v", r.Filename, err)
+	}
+	var out bytes.Buffer
+	err := tpl.Execute(&out, templateContext{
+		Filename:   r.Filename,
+		RemoteIP:   r.RemoteAddr.IP.String(),
+		RemotePort: r.RemoteAddr.Port,
+	})
+	if err != nil {
+		return fmt.Errorf("executing template %s: %v", r.Filename, err)
+	}
+
+	r.SetSize(int64(out.Len()))
+	_, err = r.ReadFrom(&out)
+	return err
+}
+
+// bufferReaderFrom captures what a handler sends to the client.
+type bufferReaderFrom struct {
+	buf *bytes.Buffer
+}
+
+func (b bufferReaderFrom) ReadFrom(r io.Reader) (int64, error) {
+	return b.buf.ReadFrom(r)
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+//
+//	templates [<patterns...>] {
+//	    match      <patterns...>
+//	    between    <open_delim> <close_delim>
+//	}
+func (t *Templates) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	t.MatchFiles = append(t.MatchFiles, d.RemainingArgs()...)
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "match":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			t.MatchFiles = append(t.MatchFiles, args...)
+		case "between":
+			t.Delimiters = d.RemainingArgs()
+			if len(t.Delimiters) != 2 {
+				return d.ArgErr()
+			}
+		default:
+			return d.Errf("unrecognized templates option '%s'", d.Val())
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Templates)(nil)
+	_ MiddlewareHandler     = (*Templates)(nil)
+	_ caddyfile.Unmarshaler = (*Templates)(nil)
+)
@@ -0,0 +1,58 @@
+package internal
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pin/tftp/v3"
+)
+
+func TestTemplates(t *testing.T) {
+	root := t.TempDir()
+	for name, data := range map[string]string{
+		"host.ipxe":   `{{.RemoteIP}} {{.Filename}} {{placeholder "tftp.server.name"}} {{upper "sprig"}}`,
+		"plain.txt":   `{{.RemoteIP}}`,
+		"broken.ipxe": `{{.RemoteIP`,
+	} {
+		if err := os.WriteFile(filepath.Join(root, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	addr := startServer(t, &Server{Root: root}, &Templates{MatchFiles: []string{"*.ipxe"}}, &FileServer{})
+
+	want := "127.0.0.1 host.ipxe test SPRIG"
+	c := newClient(t, addr)
+	c.RequestTSize(true)
+	wt, err := c.Receive("host.ipxe", "octet")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the size announced is the one of the rendered file
+	if n, ok := wt.(tftp.IncomingTransfer).Size(); !ok || n != int64(len(want)) {
+		t.Errorf("tsize %d, %v, want %d", n, ok, len(want))
+	}
+	var buf bytes.Buffer
+	if _, err := wt.WriteTo(&buf); err != nil || buf.String() != want {
+		t.Errorf("host.ipxe: got %q, %v, want %q", buf.String(), err, want)
+	}
+	// only matching files are rendered
+	if got, err := download(t, addr, "plain.txt"); err != nil || got != "{{.RemoteIP}}" {
+		t.Errorf("plain.txt: got %q, %v", got, err)
+	}
+	_, err = download(t, addr, "broken.ipxe")
+	wantCode(t, err, errCodeNotDefined)
+	_, err = download(t, addr, "missing.ipxe")
+	wantCode(t, err, errCodeFileNotFound)
+}
+
+func TestTemplatesDelimiters(t *testing.T) {
+	addr := startServer(t, &Server{},
+		&Templates{Delimiters: []string{"<<", ">>"}},
+		&StaticResponse{Body: "{{.Filename}} <<.Filename>>"},
+	)
+	if got, err := download(t, addr, "menu"); err != nil || got != "{{.Filename}} menu" {
+		t.Errorf("got %q, %v", got, err)
+	}
+}
@@ -1,18 +1,35 @@
 package internal
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
+	"maps"
 	"net"
+	"net/netip"
 	"os"
+	"path"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/modules/caddyevents"
 	"github.com/pin/tftp/v3"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
 	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -24,23 +41,50 @@ type TFTP struct {
 	// The set of ssh servers keyed by custom names
 	Servers map[string]*Server `json:"servers,omitempty"`
 
+	// The maximum time to wait for in-flight transfers to complete when
+	// the app stops, e.g. on a config reload; transfers still running
+	// after it are aborted and logged. Default is to wait for them
+	// indefinitely, except in single port mode, where they are aborted
+	// right away.
+	GracePeriod caddy.Duration `json:"grace_period,omitempty"`
+
 	servers  []*tftpServer
+	tracing  *sdktrace.TracerProvider
 	ctx      caddy.Context
+	events   *caddyevents.App
 	errGroup *errgroup.Group
 }
 
 type Server struct {
-	// Socket addresses to which to bind listeners.
-	// Accepts network addresses that may include port ranges.
+	// Socket addresses to which to bind listeners, either a single
+	// address or a list, e.g. to listen on both an IPv4 and an IPv6
+	// address. Accepts network addresses that may include port ranges.
 	// Listener addresses must be unique; they cannot be repeated across all defined servers.
-	// UDP is the only acceptable network.
-	Listen string `json:"listen,omitempty"`
+	// The acceptable networks are udp, udp4 and udp6, and fdgram for a
+	// socket inherited from systemd socket activation, e.g. fdgram/3.
+	// Global placeholders are supported, e.g. {env.TFTP_LISTEN}.
+	// Default is :69.
+	Listen ListenAddresses `json:"listen,omitempty"`
+
+	// The number of sockets to open on every listen address, each
+	// served by its own goroutine. The kernel spreads requests across
+	// them with SO_REUSEPORT, so more sockets relieve the receive path
+	// when many clients boot at once. Only supported on Unix platforms.
+	// Default is 1.
+	ListenSockets int `json:"listen_sockets,omitempty"`
 
 	// The path to the root of the site.
 	// Default is current working directory.
-	// This should be a trusted value.
+	// This should be a trusted value. Placeholders are supported,
+	// e.g. /srv/tftp/{tftp.request.remote.host} gives every client a
+	// directory of its own, which is created on its first upload.
 	Root string `json:"root,omitempty"`
 
+	// Roots for the clients in some address ranges, e.g. 10.1.0.0/16
+	// for /srv/tftp/site-a. The first one that includes a client is its
+	// root; other clients get the root above.
+	ClientRoots []ClientRoot `json:"client_roots,omitempty"`
+
 	// The maximum time to wait for a single network round-trip to succeed.
 	// Default is 5 seconds.
 	// Duration can be an integer or a string.
@@ -49,17 +93,295 @@ type Server struct {
 	// valid units are ns, us/µs, ms, s, m, h, and d.
 	Timeout caddy.Duration `json:"timeout,omitempty"`
 
-	// Enables access logging.
-	Logs bool `json:"logs,omitempty"`
+	// The maximum number of attempts to transmit a packet.
+	// Default is 5.
+	Retries int `json:"retries,omitempty"`
+
+	// The delay before retransmitting an unacknowledged packet,
+	// which doubles with every further attempt up to a minute.
+	// Default is a random delay of up to 1 second.
+	Backoff caddy.Duration `json:"backoff,omitempty"`
+
+	// The maximum time a transfer may take as a whole;
+	// longer transfers are aborted. Default is unlimited.
+	MaxTransferDuration caddy.Duration `json:"max_transfer_duration,omitempty"`
+
+	// The maximum time a transfer may go without sending or receiving
+	// any data, e.g. because the client or an upstream stalled;
+	// idle transfers are aborted. Default is unlimited.
+	IdleTimeout caddy.Duration `json:"idle_timeout,omitempty"`
+
+	// The largest block size the server agrees to when a client
+	// requests the blksize option (RFC 2348); clients requesting a
+	// larger size get this one. Must be between 513 and 65464.
+	// Either way, the block size is limited by the MTU of the interface.
+	BlockSize int `json:"block_size,omitempty"`
+
+	// The smallest block size the server agrees to when a client
+	// requests the blksize option; requests for a smaller size are
+	// rejected with error code 8 (option negotiation failed), so slow
+	// transfers fail early instead. Clients that do not request the
+	// option are still served with 512 byte blocks. Must be between
+	// 8 and 65464, and not above block_size.
+	MinBlockSize int `json:"min_block_size,omitempty"`
+
+	// The number of blocks the server sends to a reading client before
+	// it waits for an ACK, instead of waiting for one after every block.
+	// This speeds up downloads on links with a high latency, but clients
+	// that drop out-of-order blocks retransmit more. Values of 0 or 1
+	// disable it; pin/tftp caps it at 60. Experimental.
+	Anticipate uint `json:"anticipate,omitempty"`
+
+	// Serves all transfers from the listening port instead of a new port
+	// per transfer, so only the listening port has to be reachable
+	// through firewalls and NAT. This costs some performance.
+	SinglePort bool `json:"single_port,omitempty"`
+
+	// The DSCP value (0-63) to mark outgoing packets of the listening
+	// socket with, for QoS classification. The per-transfer sockets are
+	// not marked, so use single_port to mark all TFTP traffic.
+	DSCP int `json:"dscp,omitempty"`
+
+	// The size of the receive and send buffers of the listening sockets
+	// in bytes, e.g. to keep the kernel from dropping requests when many
+	// clients boot at once. The kernel may cap them, e.g. at
+	// net.core.rmem_max and net.core.wmem_max on Linux. Like dscp, they do
+	// not apply to the per-transfer sockets. Default is the kernel default.
+	ReadBuffer  int `json:"read_buffer,omitempty"`
+	WriteBuffer int `json:"write_buffer,omitempty"`
+
+	// The network interface to bind the listening sockets to, e.g. the
+	// one of the provisioning VLAN, so requests arriving on other
+	// interfaces are not answered. Only supported on Linux.
+	// Default is none.
+	BindInterface string `json:"bind_interface,omitempty"`
+
+	// The minimum level of the logs of the server: debug, info, warn or
+	// error. It can only raise the level set by Caddy's logging config.
+	// Default is the level of the logging config.
+	LogLevel string `json:"log_level,omitempty"`
+
+	// Enables access logging; true enables it with the defaults.
+	Logs *AccessLogs `json:"logs,omitempty"`
+
+	// Keeps statistics per file, which the admin API reports.
+	// Default is none.
+	FileStats *FileStats `json:"file_stats,omitempty"`
+
+	// Enables OpenTelemetry tracing with a span per transfer.
+	Tracing *Tracing `json:"tracing,omitempty"`
+
+	// Logs the packets of transfers at debug level, e.g. to find out
+	// why a client stalls halfway through a download. Default is none.
+	DebugPackets *PacketDebug `json:"debug_packets,omitempty"`
+
+	// Disables uploads; write requests are rejected with an error.
+	ReadOnly bool `json:"read_only,omitempty"`
+
+	// Disables downloads; read requests are rejected with an error.
+	WriteOnly bool `json:"write_only,omitempty"`
+
+	// What to do when an upload targets an existing file:
+	// deny rejects the upload, allow replaces the file and version
+	// keeps the previous file with a timestamp appended to its name.
+	// Default is deny.
+	Overwrite string `json:"overwrite,omitempty"`
+
+	// How to treat symlinks below the root: follow follows them wherever
+	// they point, deny_escape only follows those that stay within the root
+	// and deny refuses to follow any. Uploads never follow symlinks out
+	// of the root. Default is deny_escape.
+	Symlinks string `json:"symlinks,omitempty"`
+
+	// Translates backslashes in requested file names to slashes, for
+	// Windows clients that request e.g. Boot\BCD.
+	ConvertBackslashes bool `json:"convert_backslashes,omitempty"`
+
+	// Resolves requested file names case-insensitively against the root,
+	// preferring exact matches, e.g. boot/bcd for a request of Boot/BCD.
+	// Applies to the file_server handler. The hide patterns, access rules,
+	// file authorizer rules and upload_name deny patterns then ignore case
+	// as well, so a request cannot bypass them by changing the case.
+	CaseInsensitive bool `json:"case_insensitive,omitempty"`
+
+	// The maximum size of an upload in bytes. Uploads that announce a
+	// larger size with the tsize option are rejected right away, others
+	// are aborted once they exceed it. Default is unlimited.
+	MaxUploadSize int64 `json:"max_upload_size,omitempty"`
+
+	// The maximum size of a download in bytes. Downloads of larger files
+	// are rejected before any data is sent, and downloads of unknown size
+	// are aborted once they exceed it. Default is unlimited.
+	MaxDownloadSize int64 `json:"max_download_size,omitempty"`
+
+	// Limits the total size and number of uploads per client and across
+	// all clients; uploads beyond it are rejected with a disk full error.
+	// Default is unlimited.
+	Quota *Quota `json:"quota,omitempty"`
+
+	// IP addresses or CIDR ranges of clients that may use the server.
+	// Default is all clients.
+	Allow []string `json:"allow,omitempty"`
+
+	// IP addresses or CIDR ranges of clients that may not use the server.
+	// Deny takes precedence over allow.
+	Deny []string `json:"deny,omitempty"`
+
+	// A reserved file name, e.g. "__health", that reads "OK" instead of
+	// being passed to the handlers, so load balancers and monitoring can
+	// check cheaply that the server is serving. Clients must be allowed by
+	// allow and deny. Default is none.
+	HealthFile string `json:"health_file,omitempty"`
+
+	// Checks requests against the RFCs instead of serving them as far as
+	// possible: requests in the mail mode or an unknown mode are rejected
+	// with error code 4 (illegal operation), requests with option values
+	// out of range, e.g. a blksize of 70000, with error code 8.
+	Strict bool `json:"strict,omitempty"`
+
+	// Glob patterns of file names to hide; requests for them fail as if
+	// the file does not exist. A pattern without a slash matches any
+	// element of the path, e.g. "*.key" or ".git"; a pattern with a slash
+	// matches the path from the root, e.g. "secrets/*", including
+	// everything below the directories it matches.
+	Hide []string `json:"hide,omitempty"`
+
+	// Hides files with an element of their path starting with a dot,
+	// e.g. .git/config or .ssh/id_rsa, like the hide patterns do;
+	// uploads of such files are refused as well. Default is true.
+	HideHidden *bool `json:"hide_hidden,omitempty"`
+
+	// File name extensions that may be downloaded, e.g. [".efi", ".kpxe",
+	// ".cfg"]; downloads of other files fail as if the file does not
+	// exist, and so do those of files without an extension. Extensions
+	// are matched case-insensitively against the end of the requested
+	// name, so ".tar.gz" works too. Default is all extensions.
+	AllowedExtensions []string `json:"allowed_extensions,omitempty"`
+
+	// File name extensions that may not be downloaded, matched like
+	// allowed_extensions, which they take precedence over.
+	DeniedExtensions []string `json:"denied_extensions,omitempty"`
+
+	// Rules restricting the clients that may read or write matching
+	// files, e.g. to let only one subnet read secure/*. A request must
+	// satisfy every rule that applies to it, in addition to allow and deny.
+	AccessRules []AccessRule `json:"access_rules,omitempty"`
+
+	// An authorizer in the tftp.authorizers namespace that decides whether
+	// a request may proceed once allow, deny and the access rules let it,
+	// e.g. by asking an inventory system. Default is none.
+	AuthorizeRaw json.RawMessage `json:"authorize,omitempty" caddy:"namespace=tftp.authorizers inline_key=authorizer"`
+
+	// The maximum rate of a single transfer in bytes per second.
+	// Default is unlimited.
+	MaxRatePerTransfer int64 `json:"max_rate_per_transfer,omitempty"`
+
+	// The maximum rate of all transfers of a single client
+	// in bytes per second. Default is unlimited.
+	MaxRatePerClient int64 `json:"max_rate_per_client,omitempty"`
+
+	// The maximum rate of requests of a single client in requests per
+	// second, e.g. to keep firmware stuck in a boot loop from starving the
+	// other clients. Requests beyond it are rejected with an error right
+	// away. Default is unlimited.
+	MaxRequestsPerClient float64 `json:"max_requests_per_client,omitempty"`
+
+	// The number of requests a client may make in quick succession
+	// before max_requests_per_client applies. Default is 10.
+	RequestBurst int `json:"request_burst,omitempty"`
+
+	// The maximum number of transfers served at the same time.
+	// Default is unlimited.
+	MaxConcurrentTransfers int `json:"max_concurrent_transfers,omitempty"`
+
+	// The maximum number of requests waiting for a transfer slot when
+	// max_concurrent_transfers is reached; further requests are rejected.
+	// A request waits at most timeout for a slot.
+	// Default is 0, which rejects requests right away.
+	MaxQueuedTransfers int `json:"max_queued_transfers,omitempty"`
+
+	// The list of handlers that serve requests, invoked in order.
+	// Default is a single file_server serving the root.
+	HandlersRaw []json.RawMessage `json:"handle,omitempty" caddy:"namespace=tftp.handlers inline_key=handler"`
+}
+
+// ListenAddresses is a list of listener addresses,
+// which may also be given as a single string in JSON.
+type ListenAddresses []string
+
+// UnmarshalJSON accepts a single address as well as a list.
+func (la *ListenAddresses) UnmarshalJSON(b []byte) error {
+	var addr string
+	if err := json.Unmarshal(b, &addr); err == nil {
+		*la = ListenAddresses{addr}
+		return nil
+	}
+	var addrs []string
+	if err := json.Unmarshal(b, &addrs); err != nil {
+		return fmt.Errorf("listen must be an address or a list of addresses")
+	}
+	*la = addrs
+	return nil
 }
 
 type tftpServer struct {
+	name          string
+	root          string
+	clientRoots   []clientRoot
+	overwrite     string
+	symlinks      string
+	backslashes   bool
+	foldCase      bool
+	maxUpload     int64
+	maxDownload   int64
+	quota         *quotaTracker
+	addrs         []caddy.NetworkAddress
+	listeners     []*listener
+	singlePort    bool
+	dscp          int
+	readBuffer    int
+	writeBuffer   int
+	bindIface     string
+	handler       Handler
+	allow         []netip.Prefix
+	deny          []netip.Prefix
+	hide          []string
+	hideHidden    bool
+	allowedExts   []string
+	deniedExts    []string
+	strict        bool
+	minBlockSize  int
+	healthFile    string
+	rules         []accessRule
+	authorizer    Authorizer
+	rate          int64
+	clients       *clientLimiters
+	requests      *requestLimiters
+	slots         chan struct{}
+	maxQueued     int32
+	queued        atomic.Int32
+	draining      atomic.Bool
+	timeout       time.Duration
+	maxDuration   time.Duration
+	idle          time.Duration
+	transfers     transfers
+	stats         serverStats
+	fileStats     *fileStats
+	ctx           caddy.Context
+	events        *caddyevents.App
+	log           *zap.Logger
+	accessLog     *accessLogger
+	packetLog     *zap.Logger
+	packetClients []netip.Prefix
+	tracer        trace.Tracer
+	spanName      string
+}
+
+// listener is a socket of a server, each served by its own tftp.Server.
+type listener struct {
 	*tftp.Server
-	name      string
-	root      string
-	addr      caddy.NetworkAddress
-	log       *zap.Logger
-	accessLog *zap.Logger
+	addr caddy.NetworkAddress
+	ln   net.PacketConn
 }
 
 // CaddyModule returns the Caddy module information.
@@ -72,182 +394,1044 @@ func (TFTP) CaddyModule() caddy.ModuleInfo {
 
 func (app *TFTP) Provision(ctx caddy.Context) error {
 	app.ctx = ctx
+	eventsAppIface, err := ctx.App("events")
+	if err != nil {
+		return fmt.Errorf("getting events app: %v", err)
+	}
+	app.events = eventsAppIface.(*caddyevents.App)
+	repl := caddy.NewReplacer()
 	for name, srv := range app.Servers {
-		root, err := filepath.Abs(srv.Root)
+		// request placeholders in the root are replaced per request
+		root, err := filepath.Abs(repl.ReplaceKnown(srv.Root, ""))
 		if err != nil {
 			return err
 		}
 
-		addr, err := caddy.ParseNetworkAddressWithDefaults(srv.Listen, "udp", 69)
+		listen := srv.Listen
+		if len(listen) == 0 {
+			listen = ListenAddresses{""}
+		}
+		var addrs []caddy.NetworkAddress
+		var inherited bool
+		for _, l := range listen {
+			l, err := repl.ReplaceOrErr(l, true, true)
+			if err != nil {
+				return fmt.Errorf("server %s: listen: %v", name, err)
+			}
+			addr, err := caddy.ParseNetworkAddressWithDefaults(l, "udp", 69)
+			if err != nil {
+				return err
+			}
+			switch addr.Network {
+			case "udp", "udp4", "udp6":
+			case "fdgram":
+				inherited = true
+			case "fd":
+				return fmt.Errorf("server %s: use 'fdgram' for an inherited UDP socket", name)
+			default:
+				return fmt.Errorf("only 'udp', 'udp4', 'udp6' and 'fdgram' are supported in the listener addr")
+			}
+			addrs = append(addrs, addr)
+		}
+
+		sockets := max(srv.ListenSockets, 1)
+		if sockets > 1 && !ownsSocket {
+			return fmt.Errorf("server %s: listen_sockets is not supported on this platform", name)
+		}
+		if sockets > 1 && inherited {
+			return fmt.Errorf("server %s: listen_sockets is not supported with inherited sockets", name)
+		}
+		if srv.BindInterface != "" && inherited {
+			return fmt.Errorf("server %s: bind_interface is not supported with inherited sockets", name)
+		}
+
+		if srv.BlockSize != 0 && (srv.BlockSize <= 512 || srv.BlockSize > 65464) {
+			return fmt.Errorf("server %s: block_size must be between 513 and 65464", name)
+		}
+		if srv.MinBlockSize != 0 && (srv.MinBlockSize < 8 || srv.MinBlockSize > 65464) {
+			return fmt.Errorf("server %s: min_block_size must be between 8 and 65464", name)
+		}
+		if srv.MinBlockSize != 0 && srv.BlockSize != 0 && srv.MinBlockSize > srv.BlockSize {
+			return fmt.Errorf("server %s: min_block_size must not be above block_size", name)
+		}
+
+		if srv.BindInterface != "" && !canBindToDevice {
+			return fmt.Errorf("server %s: bind_interface is not supported on this platform", name)
+		}
+
+		if srv.DSCP < 0 || srv.DSCP > 63 {
+			return fmt.Errorf("server %s: dscp must be between 0 and 63", name)
+		}
+		if srv.ReadBuffer < 0 || srv.WriteBuffer < 0 {
+			return fmt.Errorf("server %s: read_buffer and write_buffer must not be negative", name)
+		}
+
+		if srv.ReadOnly && srv.WriteOnly {
+			return fmt.Errorf("server %s: read_only and write_only are mutually exclusive", name)
+		}
+
+		switch srv.Overwrite {
+		case "":
+			srv.Overwrite = "deny"
+		case "deny", "allow", "version":
+		default:
+			return fmt.Errorf("server %s: unrecognized overwrite policy '%s'", name, srv.Overwrite)
+		}
+
+		switch srv.Symlinks {
+		case "":
+			srv.Symlinks = "deny_escape"
+		case "follow", "deny", "deny_escape":
+		default:
+			return fmt.Errorf("server %s: unrecognized symlinks policy '%s'", name, srv.Symlinks)
+		}
+
+		for _, pattern := range srv.Hide {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("server %s: invalid hide pattern %s: %v", name, pattern, err)
+			}
+		}
+
+		var clientRoots []clientRoot
+		for i, cr := range srv.ClientRoots {
+			root, err := cr.provision(repl)
+			if err != nil {
+				return fmt.Errorf("server %s: client root %d: %v", name, i, err)
+			}
+			clientRoots = append(clientRoots, root)
+		}
+
+		var rules []accessRule
+		for i, ar := range srv.AccessRules {
+			rule, err := ar.provision()
+			if err != nil {
+				return fmt.Errorf("server %s: access rule %d: %v", name, i, err)
+			}
+			rules = append(rules, rule)
+		}
+
+		allow, err := parsePrefixes(srv.Allow)
 		if err != nil {
-			return err
+			return fmt.Errorf("server %s: allow: %v", name, err)
 		}
-		if addr.Network != "udp" {
-			return fmt.Errorf("only 'udp' is supported in the listener addr")
+		deny, err := parsePrefixes(srv.Deny)
+		if err != nil {
+			return fmt.Errorf("server %s: deny: %v", name, err)
+		}
+
+		var authorizer Authorizer
+		if srv.AuthorizeRaw != nil {
+			mod, err := ctx.LoadModule(srv, "AuthorizeRaw")
+			if err != nil {
+				return fmt.Errorf("server %s: loading authorizer module: %v", name, err)
+			}
+			authorizer = mod.(Authorizer)
+		}
+
+		var handlers []MiddlewareHandler
+		if srv.HandlersRaw != nil {
+			mods, err := ctx.LoadModule(srv, "HandlersRaw")
+			if err != nil {
+				return fmt.Errorf("server %s: loading handler modules: %v", name, err)
+			}
+			for _, mod := range mods.([]any) {
+				handlers = append(handlers, mod.(MiddlewareHandler))
+			}
+		} else {
+			fsrv := new(FileServer)
+			if err := fsrv.Provision(ctx); err != nil {
+				return err
+			}
+			handlers = append(handlers, fsrv)
 		}
 
 		log := ctx.Logger().Named(name)
+		if srv.LogLevel != "" {
+			level, err := zapcore.ParseLevel(srv.LogLevel)
+			if err != nil {
+				return fmt.Errorf("server %s: log_level: %v", name, err)
+			}
+			log = log.WithOptions(zap.IncreaseLevel(level))
+		}
+		var quota *quotaTracker
+		if srv.Quota != nil {
+			quota, err = newQuotaTracker(ctx, srv.Quota, name, log)
+			if err != nil {
+				return fmt.Errorf("server %s: quota: %v", name, err)
+			}
+		}
 		s := &tftpServer{
-			name:      name,
-			root:      root,
-			addr:      addr,
-			log:       log,
-			accessLog: log.Named("access"),
+			name:         name,
+			root:         root,
+			clientRoots:  clientRoots,
+			addrs:        addrs,
+			maxDuration:  time.Duration(srv.MaxTransferDuration),
+			idle:         time.Duration(srv.IdleTimeout),
+			overwrite:    srv.Overwrite,
+			symlinks:     srv.Symlinks,
+			backslashes:  srv.ConvertBackslashes,
+			foldCase:     srv.CaseInsensitive,
+			maxUpload:    srv.MaxUploadSize,
+			maxDownload:  srv.MaxDownloadSize,
+			quota:        quota,
+			singlePort:   srv.SinglePort,
+			dscp:         srv.DSCP,
+			readBuffer:   srv.ReadBuffer,
+			writeBuffer:  srv.WriteBuffer,
+			bindIface:    srv.BindInterface,
+			handler:      compileHandlers(handlers, notFoundHandler),
+			allow:        allow,
+			deny:         deny,
+			hide:         srv.Hide,
+			hideHidden:   srv.HideHidden == nil || *srv.HideHidden,
+			allowedExts:  normalizeExtensions(srv.AllowedExtensions),
+			deniedExts:   normalizeExtensions(srv.DeniedExtensions),
+			strict:       srv.Strict,
+			minBlockSize: srv.MinBlockSize,
+			healthFile:   strings.TrimPrefix(srv.HealthFile, "/"),
+			rules:        rules,
+			authorizer:   authorizer,
+			rate:         srv.MaxRatePerTransfer,
+			ctx:          ctx,
+			events:       app.events,
+			log:          log,
+			accessLog:    newAccessLogger(ctx, name, srv.Logs),
+		}
+		if srv.Tracing != nil {
+			if app.tracing == nil {
+				app.tracing, err = newTracerProvider(ctx)
+				if err != nil {
+					return fmt.Errorf("server %s: tracing: %v", name, err)
+				}
+			}
+			s.tracer = app.tracing.Tracer("github.com/lion7/caddytftp")
+			s.spanName = srv.Tracing.Span
+			if s.spanName == "" {
+				s.spanName = "tftp {tftp.request.method}"
+			}
+		}
+		// a tftp.Server serves a single socket, so every socket of every
+		// port of every address gets its own, sharing the handlers
+		for _, addr := range addrs {
+			for offset := range addr.PortRangeSize() {
+				port := addr
+				port.StartPort += uint(offset)
+				port.EndPort = port.StartPort
+				for range sockets {
+					sl := &listener{addr: port}
+					var readHandler func(string, io.ReaderFrom) error
+					if !srv.WriteOnly {
+						readHandler = func(filename string, rf io.ReaderFrom) error {
+							return s.readHandler(sl, filename, rf)
+						}
+					}
+					var writeHandler func(string, io.WriterTo) error
+					if !srv.ReadOnly {
+						writeHandler = func(filename string, wt io.WriterTo) error {
+							return s.writeHandler(sl, filename, wt)
+						}
+					}
+					tftpServer := tftp.NewServer(readHandler, writeHandler)
+					tftpServer.SetTimeout(time.Duration(srv.Timeout))
+					tftpServer.SetRetries(srv.Retries)
+					if srv.Backoff > 0 {
+						backoff := time.Duration(srv.Backoff)
+						tftpServer.SetBackoff(func(attempt int) time.Duration {
+							return retryBackoff(backoff, attempt)
+						})
+					}
+					tftpServer.SetBlockSize(srv.BlockSize)
+					tftpServer.SetAnticipate(srv.Anticipate)
+					if srv.SinglePort {
+						tftpServer.EnableSinglePort()
+					}
+					tftpServer.SetHook(transferHook{s})
+					sl.Server = tftpServer
+					s.listeners = append(s.listeners, sl)
+				}
+			}
+		}
+
+		if srv.MaxConcurrentTransfers > 0 {
+			s.slots = make(chan struct{}, srv.MaxConcurrentTransfers)
+			s.maxQueued = int32(srv.MaxQueuedTransfers)
+			s.timeout = time.Duration(srv.Timeout)
+			if s.timeout <= 0 {
+				s.timeout = 5 * time.Second
+			}
+		}
+		if srv.MaxRatePerClient > 0 {
+			s.clients = newClientLimiters(srv.MaxRatePerClient)
+		}
+		if srv.MaxRequestsPerClient > 0 {
+			s.requests = newRequestLimiters(srv.MaxRequestsPerClient, srv.RequestBurst)
+		}
+		if srv.FileStats != nil {
+			s.fileStats = newFileStats(srv.FileStats)
+		}
+		if srv.DebugPackets != nil {
+			s.packetClients, err = parsePrefixes(srv.DebugPackets.Clients)
+			if err != nil {
+				return fmt.Errorf("server %s: debug_packets: %v", name, err)
+			}
+			s.packetLog = log.Named("packets")
 		}
-		tftpServer := tftp.NewServer(s.readHandler, s.writeHandler)
-		tftpServer.SetTimeout(time.Duration(srv.Timeout))
-		s.Server = tftpServer
 
 		app.servers = append(app.servers, s)
 	}
 	return nil
 }
 
+// Validate rejects configs that would only fail once they replaced the
+// running one: listeners that overlap, so the servers would split the
+// requests between them, roots that are not directories, out of range
+// timeouts, and options that have no effect without another.
+func (app *TFTP) Validate() error {
+	servers := make(map[string]*tftpServer, len(app.servers))
+	for _, s := range app.servers {
+		servers[s.name] = s
+	}
+	names := slices.Sorted(maps.Keys(servers))
+	for i, name := range names {
+		s, srv := servers[name], app.Servers[name]
+		for j, addr := range s.addrs {
+			for _, other := range names[i:] {
+				for k, otherAddr := range servers[other].addrs {
+					if other == name && k <= j {
+						continue
+					}
+					if listenersOverlap(addr, otherAddr) {
+						return fmt.Errorf("server %s: listener %s overlaps listener %s of server %s", name, addr, otherAddr, other)
+					}
+				}
+			}
+		}
+
+		if !strings.Contains(s.root, "{") {
+			info, err := os.Stat(s.root)
+			switch {
+			case errors.Is(err, fs.ErrNotExist):
+				s.log.Warn("root does not exist", zap.String("root", s.root))
+			case err != nil:
+				return fmt.Errorf("server %s: root: %v", name, err)
+			case !info.IsDir():
+				return fmt.Errorf("server %s: root %s is not a directory", name, s.root)
+			}
+		}
+		if srv.BindInterface != "" {
+			if _, err := net.InterfaceByName(srv.BindInterface); err != nil {
+				return fmt.Errorf("server %s: bind_interface: %v", name, err)
+			}
+		}
+
+		if srv.Timeout < 0 || srv.Backoff < 0 || srv.MaxTransferDuration < 0 || srv.IdleTimeout < 0 {
+			return fmt.Errorf("server %s: timeout, backoff, max_transfer_duration and idle_timeout must not be negative", name)
+		}
+		if srv.Retries < 0 {
+			return fmt.Errorf("server %s: retries must not be negative", name)
+		}
+		timeout := time.Duration(srv.Timeout)
+		if timeout == 0 {
+			timeout = 5 * time.Second
+		}
+		// the range of the timeout option of RFC 2349
+		if timeout > 255*time.Second {
+			return fmt.Errorf("server %s: timeout must be at most 255s", name)
+		}
+		if srv.IdleTimeout > 0 && time.Duration(srv.IdleTimeout) <= timeout {
+			return fmt.Errorf("server %s: idle_timeout must be longer than timeout, or transfers abort while waiting for a retransmit", name)
+		}
+
+		if srv.WriteOnly && srv.HealthFile != "" {
+			return fmt.Errorf("server %s: health_file cannot be read from a write_only server", name)
+		}
+		if srv.ReadOnly && srv.Quota != nil {
+			return fmt.Errorf("server %s: quota limits uploads, which a read_only server refuses", name)
+		}
+		if srv.MaxQueuedTransfers > 0 && srv.MaxConcurrentTransfers <= 0 {
+			return fmt.Errorf("server %s: max_queued_transfers requires max_concurrent_transfers", name)
+		}
+		if srv.MaxRequestsPerClient < 0 {
+			return fmt.Errorf("server %s: max_requests_per_client must not be negative", name)
+		}
+		if srv.RequestBurst != 0 && srv.MaxRequestsPerClient == 0 {
+			return fmt.Errorf("server %s: request_burst requires max_requests_per_client", name)
+		}
+	}
+	return nil
+}
+
+// listenersOverlap reports whether sockets listening on a and b
+// would both receive requests sent to the same address.
+func listenersOverlap(a, b caddy.NetworkAddress) bool {
+	if a.Network == "fdgram" || b.Network == "fdgram" {
+		return a.Network == b.Network && a.Host == b.Host
+	}
+	if a.Network != b.Network && a.Network != "udp" && b.Network != "udp" {
+		return false
+	}
+	if a.StartPort > b.EndPort || b.StartPort > a.EndPort {
+		return false
+	}
+	return a.Host == b.Host || unspecifiedHost(a.Host) || unspecifiedHost(b.Host)
+}
+
+// unspecifiedHost reports whether host listens on all addresses.
+func unspecifiedHost(host string) bool {
+	ip := net.ParseIP(host)
+	return host == "" || (ip != nil && ip.IsUnspecified())
+}
+
 // Start starts the TFTP app.
 func (app *TFTP) Start() error {
 	app.errGroup = &errgroup.Group{}
 	for _, s := range app.servers {
-		ln, err := s.addr.Listen(app.ctx, 0, net.ListenConfig{})
-		if err != nil {
-			return fmt.Errorf("tftp: failed to listen on %s: %v", s.addr, err)
+		s.stats.started = time.Now()
+		for _, sl := range s.listeners {
+			var config net.ListenConfig
+			if s.bindIface != "" {
+				config.Control = bindToDevice(s.bindIface)
+			}
+			ln, err := sl.addr.Listen(app.ctx, 0, config)
+			if err != nil {
+				return fmt.Errorf("tftp: failed to listen on %s: %v", sl.addr, err)
+			}
+			l, ok := ln.(net.PacketConn)
+			if !ok {
+				return fmt.Errorf("tftp: failed to listen on %s: %v", sl.addr, err)
+			}
+			sl.ln = l
+			// the tftp server needs the *net.UDPConn to learn the local address
+			// and MTU of requests, without which block sizes are capped at 512
+			if u, ok := l.(interface{ Unwrap() net.PacketConn }); ok && ownsSocket {
+				l = u.Unwrap()
+			}
+			if s.dscp > 0 {
+				if err := setDSCP(l, s.dscp); err != nil {
+					return fmt.Errorf("tftp: failed to set dscp on %s: %v", sl.addr, err)
+				}
+			}
+			if err := setBuffers(l, s.readBuffer, s.writeBuffer); err != nil {
+				return fmt.Errorf("tftp: failed to set buffers on %s: %v", sl.addr, err)
+			}
+			app.errGroup.Go(func() error {
+				s.log.Info(
+					"server running",
+					zap.String("name", s.name),
+					zap.String("address", sl.addr.String()),
+					zap.String("root", s.root),
+				)
+				return sl.Serve(l)
+			})
 		}
-		l, ok := ln.(net.PacketConn)
-		if !ok {
-			return fmt.Errorf("tftp: failed to listen on %s: %v", s.addr, err)
+	}
+	return nil
+}
+
+// sendError answers the failed request r with an error packet carrying
+// the error code of err. The tftp library answers it too, but always with
+// code 1 (file not found), which makes clients like iPXE retry in vain.
+// Until the transfer has started, the client accepts a reply from any port,
+// so this one from the listening port arrives first. Afterwards it only
+// accepts the library's reply from the port of the transfer.
+func (sl *listener) sendError(r *Request, err error) {
+	if r.started {
+		r.errorCode = errCodeFileNotFound
+		if r.packetLog != nil {
+			r.logError(err)
+		}
+		return
+	}
+	r.errorCode = errorCode(err)
+	if r.packetLog != nil {
+		r.logError(err)
+	}
+	msg := err.Error()
+	p := make([]byte, 4, 5+len(msg))
+	binary.BigEndian.PutUint16(p[0:2], 5) // ERROR
+	binary.BigEndian.PutUint16(p[2:4], uint16(r.errorCode))
+	p = append(p, msg...)
+	p = append(p, 0)
+	_, _ = sl.ln.WriteTo(p, &r.RemoteAddr)
+}
+
+// setBuffers sets the sizes of the receive and send buffers of conn,
+// unless they are 0.
+func setBuffers(conn net.PacketConn, read, write int) error {
+	if read <= 0 && write <= 0 {
+		return nil
+	}
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return fmt.Errorf("unsupported connection type %T", conn)
+	}
+	if read > 0 {
+		if err := udpConn.SetReadBuffer(read); err != nil {
+			return err
+		}
+	}
+	if write > 0 {
+		if err := udpConn.SetWriteBuffer(write); err != nil {
+			return err
 		}
-		app.errGroup.Go(func() error {
-			s.log.Info(
-				"server running",
-				zap.String("name", s.name),
-				zap.String("address", s.addr.String()),
-				zap.String("root", s.root),
-			)
-			return s.Serve(l)
-		})
 	}
 	return nil
 }
 
-// Stop stops the TFTP app.
+// setDSCP marks the outgoing IPv4 and IPv6 packets of conn with dscp.
+func setDSCP(conn net.PacketConn, dscp int) error {
+	udpConn, ok := conn.(*net.UDPConn)
+	if !ok {
+		return fmt.Errorf("unsupported connection type %T", conn)
+	}
+	tos := dscp << 2
+	err4 := ipv4.NewConn(udpConn).SetTOS(tos)
+	err6 := ipv6.NewConn(udpConn).SetTrafficClass(tos)
+	if err4 != nil && err6 != nil {
+		return err4
+	}
+	return nil
+}
+
+// Stop stops the TFTP app. The servers stop accepting requests right
+// away, but in-flight transfers are allowed to finish.
 func (app *TFTP) Stop() error {
+	grace := time.Duration(app.GracePeriod)
+	var wg sync.WaitGroup
 	for _, s := range app.servers {
-		s.Shutdown()
-		s.log.Info(
-			"server stopped",
-			zap.String("name", s.name),
-			zap.String("address", s.addr.String()),
-			zap.String("root", s.root),
-		)
+		if n := s.transfers.count(); n > 0 {
+			s.log.Info(
+				"draining transfers",
+				zap.String("name", s.name),
+				zap.Int("transfers", n),
+			)
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if grace > 0 && s.singlePort {
+				// the transfers share the listening socket, so it stays
+				// open until they are done, refusing new requests
+				s.draining.Store(true)
+				s.finishTransfers(grace)
+			}
+			var listeners sync.WaitGroup
+			for _, sl := range s.listeners {
+				listeners.Add(1)
+				go func() {
+					defer listeners.Done()
+					sl.Shutdown()
+					// releases the listener; in single port mode this
+					// also unblocks Serve, as Shutdown leaves it open
+					if sl.ln != nil {
+						_ = sl.ln.Close()
+					}
+					s.log.Info(
+						"server stopped",
+						zap.String("name", s.name),
+						zap.String("address", sl.addr.String()),
+						zap.String("root", s.root),
+					)
+				}()
+			}
+			if grace > 0 && !s.singlePort {
+				// Shutdown waits for the transfers to complete
+				s.finishTransfers(grace)
+			}
+			listeners.Wait()
+		}()
 	}
+	wg.Wait()
 	return app.errGroup.Wait()
 }
 
-// readHandler is called when client starts file download from server
-func (s *tftpServer) readHandler(filename string, rf io.ReaderFrom) error {
-	var n int64
-	if s.accessLog != nil {
-		var remoteIP net.IP
-		var remotePort int
-		if ot, ok := rf.(tftp.OutgoingTransfer); ok {
-			remoteIP = ot.RemoteAddr().IP
-			remotePort = ot.RemoteAddr().Port
+// finishTransfers waits up to grace for the in-flight transfers of s to
+// complete, then aborts and logs the remaining ones.
+func (s *tftpServer) finishTransfers(grace time.Duration) {
+	deadline := time.NewTimer(grace)
+	defer deadline.Stop()
+	ticker := time.NewTicker(100 * time.Millisecond)
+	defer ticker.Stop()
+wait:
+	for s.transfers.count() > 0 {
+		select {
+		case <-ticker.C:
+		case <-deadline.C:
+			break wait
 		}
-		start := time.Now()
-		defer func() {
-			end := time.Now()
-			d := end.Sub(start)
-			s.accessLog.Info(
-				"handled request",
-				zap.String("remote_ip", remoteIP.String()),
-				zap.Int("remote_port", remotePort),
-				zap.String("method", "GET"),
-				zap.String("uri", filename),
-				zap.Int64("bytes_written", n),
-				zap.String("duration", d.String()),
+	}
+	for _, t := range s.transfers.list() {
+		if s.transfers.cancel(t.ID) {
+			s.log.Warn(
+				"transfer aborted by shutdown",
+				zap.String("transfer_id", t.ID),
+				zap.String("method", t.Method),
+				zap.String("filename", t.Filename),
+				zap.String("remote_addr", t.RemoteAddr),
+				zap.Int64("bytes", t.Bytes),
+				zap.Duration("grace_period", grace),
 			)
-		}()
+		}
 	}
+}
 
-	p, err := s.safePath(filename)
-	if err != nil {
-		s.log.Error(err.Error(), zap.String("filename", filename))
-		return err
+// Cleanup flushes and shuts down the tracer provider, if any.
+func (app *TFTP) Cleanup() error {
+	if app.tracing == nil {
+		return nil
 	}
-	file, err := os.Open(p)
-	if err != nil {
-		s.log.Error(err.Error(), zap.String("filename", filename))
-		return err
+	ctx := context.Background()
+	if err := app.tracing.ForceFlush(ctx); err != nil {
+		app.ctx.Logger().Error("flushing traces", zap.Error(err))
 	}
-	n, err = rf.ReadFrom(file)
-	if err != nil {
-		s.log.Error(err.Error(), zap.String("filename", filename))
+	return app.tracing.Shutdown(ctx)
+}
+
+// readHandler is called when client starts file download from server
+func (s *tftpServer) readHandler(sl *listener, filename string, rf io.ReaderFrom) (err error) {
+	r := newReadRequest(s.ctx, s.name, filename, s.root, rf)
+	r.Symlinks = s.symlinks
+	r.foldCase = s.foldCase
+	if len(s.clientRoots) > 0 {
+		r.Root = s.rootFor(r.RemoteAddr.IP)
+	}
+	if s.backslashes {
+		r.Filename = strings.ReplaceAll(r.Filename, `\`, "/")
+	}
+	r.hide = s.hide
+	r.checkName = s.checkName
+	r.packetLog = s.packetLogger(r)
+	if r.packetLog != nil {
+		r.logRequest()
+	}
+	start := time.Now()
+	defer func() {
+		if !s.isHealthFile(r) {
+			s.stats.record(r, filename, err)
+			if s.fileStats != nil {
+				s.fileStats.record(r, filename, time.Since(start), err)
+			}
+		}
+	}()
+	if s.accessLog != nil {
+		defer func() {
+			s.accessLog.log(r, filename, time.Since(start), err)
+		}()
+	}
+	if s.tracer != nil {
+		span := s.startSpan(r)
+		defer func() {
+			endSpan(span, r, err)
+		}()
+	}
+
+	s.emit("read_started", r, nil)
+	if err := s.serve(r); err != nil {
+		s.log.Error(err.Error(), zap.String("transfer_id", r.ID), zap.String("filename", filename))
+		s.emit("transfer_failed", r, err)
+		sl.sendError(r, err)
 		return err
 	}
+	s.emit("read_completed", r, nil)
 	return nil
 }
 
 // writeHandler is called when client starts file upload to server
-func (s *tftpServer) writeHandler(filename string, wt io.WriterTo) error {
-	var n int64
-	if s.accessLog != nil {
-		var remoteIP net.IP
-		var remotePort int
-		if ot, ok := wt.(tftp.IncomingTransfer); ok {
-			remoteIP = ot.RemoteAddr().IP
-			remotePort = ot.RemoteAddr().Port
+func (s *tftpServer) writeHandler(sl *listener, filename string, wt io.WriterTo) (err error) {
+	r := newWriteRequest(s.ctx, s.name, filename, s.root, wt)
+	r.Overwrite = s.overwrite
+	r.Symlinks = s.symlinks
+	r.foldCase = s.foldCase
+	if len(s.clientRoots) > 0 {
+		r.Root = s.rootFor(r.RemoteAddr.IP)
+	}
+	if s.backslashes {
+		r.Filename = strings.ReplaceAll(r.Filename, `\`, "/")
+	}
+	r.checkName = s.checkName
+	r.packetLog = s.packetLogger(r)
+	if r.packetLog != nil {
+		r.logRequest()
+	}
+	defer func() {
+		if len(r.after) > 0 {
+			go s.runAfterUpload(r)
 		}
-		start := time.Now()
+	}()
+	start := time.Now()
+	defer func() {
+		s.stats.record(r, filename, err)
+		if s.fileStats != nil {
+			s.fileStats.record(r, filename, time.Since(start), err)
+		}
+	}()
+	if s.accessLog != nil {
 		defer func() {
-			end := time.Now()
-			d := end.Sub(start)
-			s.accessLog.Info(
-				"handled request",
-				zap.String("remote_ip", remoteIP.String()),
-				zap.Int("remote_port", remotePort),
-				zap.String("method", "PUT"),
-				zap.String("uri", filename),
-				zap.Int64("bytes_read", n),
-				zap.String("duration", d.String()),
-			)
+			s.accessLog.log(r, filename, time.Since(start), err)
+		}()
+	}
+	if s.tracer != nil {
+		span := s.startSpan(r)
+		defer func() {
+			endSpan(span, r, err)
 		}()
 	}
 
-	p, err := s.safePath(filename)
-	if err != nil {
-		s.log.Error(err.Error(), zap.String("filename", filename))
+	s.emit("write_started", r, nil)
+	if err := s.serve(r); err != nil {
+		s.log.Error(err.Error(), zap.String("transfer_id", r.ID), zap.String("filename", filename))
+		s.emit("transfer_failed", r, err)
+		sl.sendError(r, err)
 		return err
 	}
-	file, err := os.OpenFile(p, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
-	if err != nil {
-		s.log.Error(err.Error(), zap.String("filename", filename))
+	s.emit("write_completed", r, nil)
+	return nil
+}
+
+// runAfterUpload runs the functions registered with afterUpload for r,
+// whose transfer is done. The timeouts of the transfer no longer apply,
+// so r gets the context of the server, keeping its placeholders.
+func (s *tftpServer) runAfterUpload(r *Request) {
+	r.ctx = context.WithValue(s.ctx, caddy.ReplacerCtxKey, r.Replacer())
+	for _, f := range r.after {
+		f()
+	}
+}
+
+// checkBlockSize rejects requests for a block size below min_block_size.
+// Only the lower case option is checked, as the tftp library ignores
+// other spellings and uses 512 byte blocks for them.
+func (s *tftpServer) checkBlockSize(r *Request) error {
+	value, ok := r.requested["blksize"]
+	if s.minBlockSize == 0 || !ok {
+		return nil
+	}
+	if n, err := strconv.Atoi(value); err != nil || n < s.minBlockSize {
+		return fmt.Errorf("%w: blksize=%s is below %d", errInvalidOption, value, s.minBlockSize)
+	}
+	return nil
+}
+
+// serve checks whether the client may use the server
+// and passes the request to the handlers.
+func (s *tftpServer) serve(r *Request) error {
+	if s.strict {
+		if err := strictRequest(r); err != nil {
+			s.log.Warn(
+				"request rejected by strict mode",
+				zap.String("transfer_id", r.ID),
+				zap.String("remote_ip", r.RemoteAddr.IP.String()),
+				zap.String("method", r.Method),
+				zap.String("filename", r.Filename),
+				zap.Error(err),
+			)
+			return err
+		}
+	}
+	if err := s.checkBlockSize(r); err != nil {
+		s.log.Warn(
+			"request rejected by min_block_size",
+			zap.String("transfer_id", r.ID),
+			zap.String("remote_ip", r.RemoteAddr.IP.String()),
+			zap.String("method", r.Method),
+			zap.String("filename", r.Filename),
+			zap.Error(err),
+		)
 		return err
 	}
-	n, err = wt.WriteTo(file)
-	if err != nil {
-		s.log.Error(err.Error(), zap.String("filename", filename))
+	if !s.allowed(r.RemoteAddr.IP) {
+		s.log.Warn(
+			"client rejected",
+			zap.String("transfer_id", r.ID),
+			zap.String("remote_ip", r.RemoteAddr.IP.String()),
+			zap.String("method", r.Method),
+			zap.String("filename", r.Filename),
+		)
+		return errAccessViolation
+	}
+	// health checks fail too, so load balancers stop sending clients
+	if s.draining.Load() {
+		return errDraining
+	}
+	if s.isHealthFile(r) {
+		return serveHealth(r)
+	}
+	if s.requests != nil && !s.requests.allow(r.RemoteAddr.IP.String()) {
+		// debug only, a client hammering the server would flood the log
+		s.log.Debug(
+			"request rate limited",
+			zap.String("transfer_id", r.ID),
+			zap.String("remote_ip", r.RemoteAddr.IP.String()),
+			zap.String("method", r.Method),
+			zap.String("filename", r.Filename),
+		)
+		return errTooManyRequests
+	}
+	if err := s.checkName(r); err != nil {
 		return err
 	}
+	if r.Method == MethodWrite && s.maxUpload > 0 {
+		if n, ok := r.Size(); ok && n > s.maxUpload {
+			s.log.Warn(
+				"upload rejected",
+				zap.String("transfer_id", r.ID),
+				zap.String("remote_ip", r.RemoteAddr.IP.String()),
+				zap.String("filename", r.Filename),
+				zap.Int64("size", n),
+			)
+			return errFileTooLarge
+		}
+		r.maxBytes = s.maxUpload
+	}
+	if r.Method == MethodRead {
+		r.maxBytes = s.maxDownload
+	}
+	if r.Method == MethodWrite && s.quota != nil {
+		left, err := s.quota.remaining(r.RemoteAddr.IP.String(), r.Root)
+		if n, ok := r.Size(); err == nil && ok && left > 0 && n > left {
+			err = errQuotaExceeded
+		}
+		if err != nil {
+			s.log.Warn(
+				"upload rejected by quota",
+				zap.String("transfer_id", r.ID),
+				zap.String("remote_ip", r.RemoteAddr.IP.String()),
+				zap.String("filename", r.Filename),
+			)
+			return err
+		}
+		if left > 0 && (r.maxBytes == 0 || left < r.maxBytes) {
+			r.maxBytes = left
+		}
+	}
+	if s.slots != nil {
+		if err := s.acquireSlot(r); err != nil {
+			s.log.Warn(
+				"transfer rejected",
+				zap.String("transfer_id", r.ID),
+				zap.String("remote_ip", r.RemoteAddr.IP.String()),
+				zap.String("method", r.Method),
+				zap.String("filename", r.Filename),
+				zap.Error(err),
+			)
+			return err
+		}
+		defer func() { <-s.slots }()
+	}
+	if s.rate > 0 {
+		r.limiters = append(r.limiters, newLimiter(s.rate))
+	}
+	if s.clients != nil {
+		client := r.RemoteAddr.IP.String()
+		r.limiters = append(r.limiters, s.clients.acquire(client))
+		defer s.clients.release(client)
+	}
+
+	ctx, cancel := context.WithCancelCause(r.ctx)
+	defer cancel(nil)
+	if s.maxDuration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeoutCause(ctx, s.maxDuration, errTransferTooLong)
+		defer cancelTimeout()
+	}
+	if s.idle > 0 {
+		r.idle = time.AfterFunc(s.idle, func() { cancel(errTransferIdle) })
+		r.idleTimeout = s.idle
+		defer r.idle.Stop()
+	}
+	r.ctx = ctx
+	t := s.transfers.add(r, func() { cancel(context.Canceled) })
+	defer s.transfers.remove(t)
+	if r.Method == MethodWrite {
+		defer transferHook{s}.recordUpload(r, time.Now())
+	}
+
+	if err := s.handler.ServeTFTP(r); err != nil {
+		return err
+	}
+	if r.Method == MethodWrite && s.quota != nil {
+		s.quota.record(r.RemoteAddr.IP.String(), r.Root, r.Bytes())
+	}
 	return nil
 }
 
-func (s *tftpServer) safePath(filename string) (string, error) {
-	p := filepath.Join(s.root, filename)
-	c, err := filepath.Abs(p)
-	s.log.Debug(
-		"sanitized path join",
-		zap.String("root", s.root),
-		zap.String("filename", filename),
-		zap.String("result", c),
-	)
-	if err != nil || !strings.HasPrefix(c, s.root) {
-		return c, errors.New("unsafe or invalid filename specified")
-	} else {
-		return c, nil
+// maxBackoff caps the delay before retransmitting a packet.
+const maxBackoff = time.Minute
+
+// retryBackoff returns the delay before the given attempt to retransmit
+// a packet: backoff doubled with every attempt, up to maxBackoff unless
+// backoff is longer by itself. Doubling stops there, so it never overflows.
+func retryBackoff(backoff time.Duration, attempt int) time.Duration {
+	d := backoff
+	for range attempt {
+		if d >= maxBackoff {
+			break
+		}
+		d *= 2
+	}
+	return max(min(d, maxBackoff), backoff)
+}
+
+// checkName checks the file name of r against the hide patterns, the
+// extensions, the access rules and the authorizer of the server. Handlers
+// that rename uploads check the new name again with Request.checkName.
+func (s *tftpServer) checkName(r *Request) error {
+	if s.hidden(r.Filename) {
+		return fs.ErrNotExist
+	}
+	if r.Method == MethodRead && !s.extensionAllowed(r.Filename) {
+		return fs.ErrNotExist
+	}
+	if !s.permitted(r) {
+		s.log.Warn(
+			"client rejected by access rule",
+			zap.String("transfer_id", r.ID),
+			zap.String("remote_ip", r.RemoteAddr.IP.String()),
+			zap.String("method", r.Method),
+			zap.String("filename", r.Filename),
+		)
+		return errAccessViolation
+	}
+	if s.authorizer != nil {
+		ok, err := s.authorizer.Authorize(r)
+		if err != nil {
+			s.log.Error(
+				"authorizing request",
+				zap.String("transfer_id", r.ID),
+				zap.String("remote_ip", r.RemoteAddr.IP.String()),
+				zap.String("method", r.Method),
+				zap.String("filename", r.Filename),
+				zap.Error(err),
+			)
+			return errAccessViolation
+		}
+		if !ok {
+			s.log.Warn(
+				"client rejected by authorizer",
+				zap.String("transfer_id", r.ID),
+				zap.String("remote_ip", r.RemoteAddr.IP.String()),
+				zap.String("method", r.Method),
+				zap.String("filename", r.Filename),
+			)
+			return errAccessViolation
+		}
+	}
+	return nil
+}
+
+// acquireSlot reserves one of the max_concurrent_transfers slots,
+// waiting in the queue for at most the timeout if they are all taken.
+func (s *tftpServer) acquireSlot(r *Request) error {
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	default:
+	}
+	if s.queued.Add(1) > s.maxQueued {
+		s.queued.Add(-1)
+		return errServerBusy
+	}
+	defer s.queued.Add(-1)
+	timer := time.NewTimer(s.timeout)
+	defer timer.Stop()
+	select {
+	case s.slots <- struct{}{}:
+		return nil
+	case <-timer.C:
+		return errServerBusy
+	case <-r.Context().Done():
+		return r.Context().Err()
+	}
+}
+
+// allowed reports whether ip matches the allow list and not the deny list.
+func (s *tftpServer) allowed(ip net.IP) bool {
+	return allowedBy(ip, s.allow, s.deny)
+}
+
+// hidden reports whether filename matches one of the hide patterns,
+// or is a dotfile or below a dot directory if those are hidden.
+func (s *tftpServer) hidden(filename string) bool {
+	if s.hideHidden && slices.ContainsFunc(strings.Split(path.Clean("/"+filename), "/"), isDotfile) {
+		return true
+	}
+	return len(s.hide) > 0 && matchPathFold(s.hide, filename, s.foldCase)
+}
+
+// extensionAllowed reports whether filename has one of the allowed
+// extensions, if any, and none of the denied ones.
+func (s *tftpServer) extensionAllowed(filename string) bool {
+	if len(s.allowedExts) == 0 && len(s.deniedExts) == 0 {
+		return true
+	}
+	base := strings.ToLower(path.Base(path.Clean("/" + filename)))
+	hasSuffix := func(ext string) bool { return strings.HasSuffix(base, ext) }
+	if slices.ContainsFunc(s.deniedExts, hasSuffix) {
+		return false
+	}
+	return len(s.allowedExts) == 0 || slices.ContainsFunc(s.allowedExts, hasSuffix)
+}
+
+// normalizeExtensions lowercases extensions and gives them a leading dot.
+func normalizeExtensions(exts []string) []string {
+	normalized := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		if ext = strings.ToLower(ext); !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		normalized = append(normalized, ext)
+	}
+	return normalized
+}
+
+// isDotfile reports whether the path element elem starts with a dot.
+func isDotfile(elem string) bool {
+	return strings.HasPrefix(elem, ".")
+}
+
+// parsePrefixes parses IP addresses and CIDR ranges.
+func parsePrefixes(values []string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, value := range values {
+		if strings.Contains(value, "/") {
+			prefix, err := netip.ParsePrefix(value)
+			if err != nil {
+				return nil, err
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(value)
+		if err != nil {
+			return nil, err
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// emit fires a "tftp.<name>" event describing a transfer.
+func (s *tftpServer) emit(name string, r *Request, err error) {
+	if s.events == nil {
+		return
+	}
+	data := map[string]any{
+		"transfer_id": r.ID,
+		"server":      s.name,
+		"filename":    r.Filename,
+		"remote_addr": r.RemoteAddr.String(),
+		"bytes":       r.Bytes(),
+	}
+	if err != nil {
+		data["error"] = err.Error()
 	}
+	s.events.Emit(s.ctx, "tftp."+name, data)
 }
 
 // Interface guards
 var (
-	_ caddy.Provisioner = (*TFTP)(nil)
-	_ caddy.App         = (*TFTP)(nil)
+	_ caddy.Provisioner  = (*TFTP)(nil)
+	_ caddy.Validator    = (*TFTP)(nil)
+	_ caddy.App          = (*TFTP)(nil)
+	_ caddy.CleanerUpper = (*TFTP)(nil)
 )
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/pin/tftp/v3"
+)
+
+// startServer runs a TFTP app serving srv on a loopback port and returns
+// the address of the server. Handlers, if given, are provisioned and
+// replace the default file server before the app starts.
+func startServer(t *testing.T, srv *Server, handlers ...MiddlewareHandler) string {
+	t.Helper()
+	return startApp(t, srv, handlers...).servers[0].listeners[0].ln.LocalAddr().String()
+}
+
+// startApp is like startServer, but returns the running app.
+func startApp(t *testing.T, srv *Server, handlers ...MiddlewareHandler) *TFTP {
+	t.Helper()
+	if len(srv.Listen) == 0 {
+		srv.Listen = ListenAddresses{"127.0.0.1:0"}
+	}
+	if srv.Timeout == 0 {
+		srv.Timeout = caddy.Duration(time.Second)
+	}
+	data, err := json.Marshal(map[string]any{
+		"admin":   map[string]any{"disabled": true},
+		"logging": map[string]any{"logs": map[string]any{"default": map[string]any{"level": "ERROR"}}},
+		"apps":    map[string]any{"tftp": &TFTP{Servers: map[string]*Server{"test": srv}}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cfg caddy.Config
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		t.Fatal(err)
+	}
+	// provisioned without starting, so the handlers are in place before
+	// the first request
+	ctx, err := caddy.ProvisionContext(&cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod, err := ctx.App("tftp")
+	if err != nil {
+		t.Fatal(err)
+	}
+	app := mod.(*TFTP)
+	s := app.servers[0]
+	if len(handlers) > 0 {
+		for _, h := range handlers {
+			if p, ok := h.(caddy.Provisioner); ok {
+				if err := p.Provision(ctx); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+		s.handler = compileHandlers(handlers, notFoundHandler)
+	}
+	if err := app.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = app.Stop() })
+	return app
+}
+
+// newClient returns a client of the server at addr.
+func newClient(t *testing.T, addr string) *tftp.Client {
+	t.Helper()
+	c, err := tftp.NewClient(addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.SetTimeout(time.Second)
+	c.SetRetries(2)
+	return c
+}
+
+// download fetches filename from the server at addr.
+func download(t *testing.T, addr, filename string) (string, error) {
+	t.Helper()
+	wt, err := newClient(t, addr).Receive(filename, "octet")
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	_, err = wt.WriteTo(&buf)
+	return buf.String(), err
+}
+
+// upload sends data as filename to the server at addr.
+func upload(t *testing.T, addr, filename, data string) error {
+	t.Helper()
+	rf, err := newClient(t, addr).Send(filename, "octet")
+	if err != nil {
+		return err
+	}
+	_, err = rf.ReadFrom(strings.NewReader(data))
+	return err
+}
+
+// wantCode fails t unless err is an error packet with the TFTP error code.
+func wantCode(t *testing.T, err error, code int) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("succeeded, want error code %d", code)
+	}
+	// downloads and uploads report error packets differently
+	n := strconv.Itoa(code)
+	if !strings.Contains(err.Error(), "code: "+n+",") && !strings.Contains(err.Error(), "code="+n+",") {
+		t.Fatalf("got %v, want error code %d", err, code)
+	}
+}
+
+func TestMinBlockSize(t *testing.T) {
+	addr := startServer(t, &Server{MinBlockSize: 1024}, &StaticResponse{Body: "body"})
+	for _, tc := range []struct {
+		blksize int
+		ok      bool
+	}{
+		{0, true},
+		{512, false},
+		{1024, true},
+		{1468, true},
+	} {
+		c := newClient(t, addr)
+		if tc.blksize != 0 {
+			c.SetBlockSize(tc.blksize)
+		}
+		wt, err := c.Receive("file", "octet")
+		if err == nil {
+			_, err = wt.WriteTo(io.Discard)
+		}
+		if tc.ok && err != nil {
+			t.Errorf("blksize %d: %v", tc.blksize, err)
+		}
+		if !tc.ok {
+			wantCode(t, err, errCodeOptionNegotiate)
+		}
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+	for _, tc := range []struct {
+		backoff time.Duration
+		attempt int
+		want    time.Duration
+	}{
+		{time.Second, 0, time.Second},
+		{time.Second, 3, 8 * time.Second},
+		{time.Second, 6, time.Minute},
+		{time.Second, 70, time.Minute},
+		{time.Second, 1 << 30, time.Minute},
+		{2 * time.Minute, 5, 2 * time.Minute},
+	} {
+		if got := retryBackoff(tc.backoff, tc.attempt); got != tc.want {
+			t.Errorf("retryBackoff(%s, %d) = %s, want %s", tc.backoff, tc.attempt, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package internal
+
+import (
+	"context"
+	"io"
+	"maps"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+// maxBurst is the largest chunk a throttled stream passes at once;
+// it matches the largest possible TFTP block.
+const maxBurst = 65464
+
+// newLimiter returns a token bucket limiting to bytesPerSecond.
+func newLimiter(bytesPerSecond int64) *rate.Limiter {
+	return rate.NewLimiter(rate.Limit(bytesPerSecond), maxBurst)
+}
+
+// throttledReader delays reads until all limiters allow them.
+type throttledReader struct {
+	ctx      context.Context
+	r        io.Reader
+	limiters []*rate.Limiter
+}
+
+func (t *throttledReader) Read(p []byte) (int, error) {
+	if len(p) > maxBurst {
+		p = p[:maxBurst]
+	}
+	n, err := t.r.Read(p)
+	if n > 0 {
+		if werr := waitAll(t.ctx, t.limiters, n); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+// throttledWriter delays writes until all limiters allow them.
+type throttledWriter struct {
+	ctx      context.Context
+	w        io.Writer
+	limiters []*rate.Limiter
+}
+
+func (t *throttledWriter) Write(p []byte) (int, error) {
+	var written int
+	for len(p) > 0 {
+		chunk := p
+		if len(chunk) > maxBurst {
+			chunk = chunk[:maxBurst]
+		}
+		if err := waitAll(t.ctx, t.limiters, len(chunk)); err != nil {
+			return written, err
+		}
+		n, err := t.w.Write(chunk)
+		written += n
+		if err != nil {
+			return written, err
+		}
+		p = p[n:]
+	}
+	return written, nil
+}
+
+func waitAll(ctx context.Context, limiters []*rate.Limiter, n int) error {
+	for _, l := range limiters {
+		if err := l.WaitN(ctx, n); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// clientLimiters shares a limiter between the transfers of a client.
+// A limiter is dropped once the client has no transfers left.
+type clientLimiters struct {
+	bytesPerSecond int64
+
+	mu       sync.Mutex
+	limiters map[string]*clientLimiter
+}
+
+type clientLimiter struct {
+	*rate.Limiter
+	transfers int
+}
+
+func newClientLimiters(bytesPerSecond int64) *clientLimiters {
+	return &clientLimiters{
+		bytesPerSecond: bytesPerSecond,
+		limiters:       make(map[string]*clientLimiter),
+	}
+}
+
+// acquire returns the limiter of client; it must be released with
+// release once the transfer is done.
+func (c *clientLimiters) acquire(client string) *rate.Limiter {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.limiters[client]
+	if !ok {
+		l = &clientLimiter{Limiter: newLimiter(c.bytesPerSecond)}
+		c.limiters[client] = l
+	}
+	l.transfers++
+	return l.Limiter
+}
+
+func (c *clientLimiters) release(client string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	l, ok := c.limiters[client]
+	if !ok {
+		return
+	}
+	l.transfers--
+	if l.transfers <= 0 {
+		delete(c.limiters, client)
+	}
+}
+
+// requestLimiters limits the rate of requests of each client.
+// A limiter is dropped once its bucket is full again, as a new one
+// would allow the same.
+type requestLimiters struct {
+	limit rate.Limit
+	burst int
+
+	mu       sync.Mutex
+	limiters map[string]*rate.Limiter
+	swept    time.Time
+}
+
+func newRequestLimiters(perSecond float64, burst int) *requestLimiters {
+	if burst <= 0 {
+		burst = 10
+	}
+	return &requestLimiters{
+		limit:    rate.Limit(perSecond),
+		burst:    burst,
+		limiters: make(map[string]*rate.Limiter),
+		swept:    time.Now(),
+	}
+}
+
+// allow reports whether client may make another request now.
+func (rl *requestLimiters) allow(client string) bool {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	now := time.Now()
+	if now.Sub(rl.swept) >= time.Minute {
+		rl.swept = now
+		maps.DeleteFunc(rl.limiters, func(_ string, l *rate.Limiter) bool {
+			return l.TokensAt(now) >= float64(rl.burst)
+		})
+	}
+	l, ok := rl.limiters[client]
+	if !ok {
+		l = rate.NewLimiter(rl.limit, rl.burst)
+		rl.limiters[client] = l
+	}
+	return l.AllowN(now, 1)
+}
@@ -0,0 +1,67 @@
+package internal
+
+import (
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestThrottle(t *testing.T) {
+	// the first maxBurst bytes pass at once, the rest at the rate
+	data := strings.Repeat("x", maxBurst+80_000)
+	addr := startServer(t, &Server{MaxRatePerTransfer: 100_000}, &StaticResponse{Body: data})
+
+	start := time.Now()
+	if got, err := download(t, addr, "file"); err != nil || got != data {
+		t.Fatalf("got %d bytes, %v", len(got), err)
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Errorf("download took %s, want about 800ms at 100000 bytes/s", elapsed)
+	}
+}
+
+func TestThrottlePerClient(t *testing.T) {
+	data := strings.Repeat("x", 80_000)
+	addr := startServer(t, &Server{MaxRatePerClient: 100_000}, &StaticResponse{Body: data})
+
+	// two transfers share the rate of the client
+	start := time.Now()
+	var wg sync.WaitGroup
+	errs := make(chan error, 2)
+	for range 2 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := download(t, addr, "file")
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if elapsed := time.Since(start); elapsed < 800*time.Millisecond {
+		t.Errorf("downloads took %s, want about 950ms at 100000 bytes/s", elapsed)
+	}
+}
+
+func TestClientLimitersRelease(t *testing.T) {
+	c := newClientLimiters(1000)
+	a := c.acquire("192.0.2.1")
+	if b := c.acquire("192.0.2.1"); b != a {
+		t.Error("transfers of a client got different limiters")
+	}
+	if b := c.acquire("192.0.2.2"); b == a {
+		t.Error("different clients got the same limiter")
+	}
+	c.release("192.0.2.1")
+	c.release("192.0.2.1")
+	c.release("192.0.2.2")
+	if len(c.limiters) != 0 {
+		t.Errorf("%d limiters left after all transfers were done", len(c.limiters))
+	}
+}
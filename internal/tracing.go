@@ -0,0 +1,82 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/caddyserver/caddy/v2"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracegrpc"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Tracing configures the OpenTelemetry tracing of the transfers of a server.
+// The spans are exported over OTLP, which is configured with the standard
+// environment variables, e.g. OTEL_EXPORTER_OTLP_ENDPOINT.
+type Tracing struct {
+	// The name of the span of a transfer. Placeholders are supported.
+	// Default is "tftp {tftp.request.method}".
+	Span string `json:"span,omitempty"`
+}
+
+// newTracerProvider creates the tracer provider shared by the servers of an app.
+func newTracerProvider(ctx context.Context) (*sdktrace.TracerProvider, error) {
+	version, _ := caddy.Version()
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		semconv.WebEngineName("Caddy"),
+		semconv.WebEngineVersion(version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("creating resource: %v", err)
+	}
+	exporter, err := otlptracegrpc.New(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("creating trace exporter: %v", err)
+	}
+	return sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	), nil
+}
+
+// startSpan starts the span of the transfer of r, which
+// becomes the parent of the spans handlers create for it.
+func (s *tftpServer) startSpan(r *Request) trace.Span {
+	name := r.Replacer().ReplaceAll(s.spanName, "")
+	ctx, span := s.tracer.Start(r.ctx, name,
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("tftp.transfer_id", r.ID),
+			attribute.String("tftp.server", s.name),
+			attribute.String("tftp.method", r.Method),
+			attribute.String("tftp.filename", r.Filename),
+			semconv.ClientAddress(r.RemoteAddr.IP.String()),
+			semconv.ClientPort(r.RemoteAddr.Port),
+		),
+	)
+	r.ctx = ctx
+	return span
+}
+
+// endSpan ends the span of the transfer of r, which failed with err, if not nil.
+func endSpan(span trace.Span, r *Request, err error) {
+	span.SetAttributes(
+		attribute.Int64("tftp.bytes", r.Bytes()),
+		attribute.Int("tftp.retransmits", r.retransmits),
+	)
+	for _, opt := range []string{"blksize", "tsize", "timeout"} {
+		if v, err := strconv.ParseInt(r.options[opt], 10, 64); err == nil {
+			span.SetAttributes(attribute.Int64("tftp."+opt, v))
+		}
+	}
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
@@ -0,0 +1,113 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"slices"
+	"sync"
+	"time"
+)
+
+// transfer is an in-flight transfer of a server.
+type transfer struct {
+	id       string
+	request  *Request
+	filename string
+	start    time.Time
+	cancel   context.CancelFunc
+}
+
+// transferInfo describes a transfer in the admin API.
+type transferInfo struct {
+	ID         string    `json:"id"`
+	Method     string    `json:"method"`
+	Filename   string    `json:"filename"`
+	RemoteAddr string    `json:"remote_addr"`
+	Bytes      int64     `json:"bytes"`
+	Start      time.Time `json:"start"`
+	Elapsed    string    `json:"elapsed"`
+}
+
+// transfers keeps track of the in-flight transfers of a server.
+type transfers struct {
+	mu sync.Mutex
+	m  map[string]*transfer
+}
+
+// add registers r as in-flight; cancel must abort the transfer.
+func (ts *transfers) add(r *Request, cancel context.CancelFunc) *transfer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.m == nil {
+		ts.m = make(map[string]*transfer)
+	}
+	t := &transfer{
+		id:       r.ID,
+		request:  r,
+		filename: r.Filename, // handlers may rewrite r.Filename concurrently
+		start:    time.Now(),
+		cancel:   cancel,
+	}
+	ts.m[t.id] = t
+	return t
+}
+
+func (ts *transfers) remove(t *transfer) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	delete(ts.m, t.id)
+}
+
+// cancel aborts the transfer with the given ID,
+// reporting whether it was in-flight.
+func (ts *transfers) cancel(id string) bool {
+	ts.mu.Lock()
+	t, ok := ts.m[id]
+	ts.mu.Unlock()
+	if ok {
+		t.cancel()
+	}
+	return ok
+}
+
+// find returns the in-flight transfer of the client at ip and port, if any.
+func (ts *transfers) find(ip net.IP, port int) *transfer {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	for _, t := range ts.m {
+		if t.request.RemoteAddr.Port == port && t.request.RemoteAddr.IP.Equal(ip) {
+			return t
+		}
+	}
+	return nil
+}
+
+// count returns the number of in-flight transfers.
+func (ts *transfers) count() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	return len(ts.m)
+}
+
+// list describes the in-flight transfers, oldest first.
+func (ts *transfers) list() []transferInfo {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	now := time.Now()
+	infos := make([]transferInfo, 0, len(ts.m))
+	for _, t := range ts.m {
+		infos = append(infos, transferInfo{
+			ID:         t.id,
+			Method:     t.request.Method,
+			Filename:   t.filename,
+			RemoteAddr: t.request.RemoteAddr.String(),
+			Bytes:      t.request.Bytes(),
+			Start:      t.start,
+			Elapsed:    now.Sub(t.start).String(),
+		})
+	}
+	slices.SortFunc(infos, func(a, b transferInfo) int {
+		return a.Start.Compare(b.Start)
+	})
+	return infos
+}
@@ -0,0 +1,191 @@
+package internal
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"unicode"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(UploadName{})
+}
+
+// UploadName decides the names uploads are stored under, as devices
+// send names with spaces, colons or the same name as their neighbours.
+// It rejects unwanted names, optionally renames them with a template and
+// slugifies them, then passes the upload on with the resulting name, which
+// is checked like the requested one. Downloads pass through unchanged.
+type UploadName struct {
+	// Glob patterns of upload names to reject with an access violation,
+	// matched like the hide patterns of the server, e.g. "*.exe".
+	Deny []string `json:"deny,omitempty"`
+
+	// Characters an upload name may not contain, e.g. ":*?\\".
+	// Uploads with such a name are rejected with an access violation.
+	DenyChars string `json:"deny_chars,omitempty"`
+
+	// Replaces every run of characters other than letters, digits,
+	// dots, dashes and underscores in the elements of the name with a
+	// dash, e.g. "my file:1.log" becomes "my-file-1.log". It applies to
+	// the renamed name, so the colons of an IPv6 {tftp.client.ip} are
+	// replaced too.
+	Slugify bool `json:"slugify,omitempty"`
+
+	// The name to store the upload under, which supports placeholders,
+	// e.g. "{tftp.client.ip}-{time.now.unix}-{tftp.request.file}".
+	// Default is the name requested by the client.
+	Rename string `json:"rename,omitempty"`
+
+	log *zap.Logger
+}
+
+// CaddyModule returns the Caddy module information.
+func (UploadName) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.handlers.upload_name",
+		New: func() caddy.Module { return new(UploadName) },
+	}
+}
+
+// Provision validates the configuration.
+func (u *UploadName) Provision(ctx caddy.Context) error {
+	u.log = ctx.Logger()
+	for _, pattern := range u.Deny {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %s: %v", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ServeTFTP implements MiddlewareHandler.
+func (u *UploadName) ServeTFTP(r *Request, next Handler) error {
+	if r.Method != MethodWrite {
+		return next.ServeTFTP(r)
+	}
+	if u.denied(r, r.Filename) {
+		u.log.Warn(
+			"upload name rejected",
+			zap.String("transfer_id", r.ID),
+			zap.String("remote_ip", r.RemoteAddr.IP.String()),
+			zap.String("filename", r.Filename),
+		)
+		return errAccessViolation
+	}
+	filename := r.Filename
+	if u.Rename != "" {
+		r.Filename = r.Replacer().ReplaceAll(u.Rename, "")
+	}
+	if u.Slugify {
+		r.Filename = slugify(r.Filename)
+	}
+	if r.Filename == filename {
+		return next.ServeTFTP(r)
+	}
+	u.log.Debug(
+		"renamed upload",
+		zap.String("transfer_id", r.ID),
+		zap.String("filename", filename),
+		zap.String("result", r.Filename),
+	)
+	// the new name must pass the checks the requested one did
+	if base := path.Base(path.Clean("/" + r.Filename)); base == "/" || base == "-" || u.denied(r, r.Filename) {
+		u.log.Warn(
+			"renamed upload rejected",
+			zap.String("transfer_id", r.ID),
+			zap.String("remote_ip", r.RemoteAddr.IP.String()),
+			zap.String("filename", filename),
+			zap.String("result", r.Filename),
+		)
+		return errAccessViolation
+	}
+	if r.checkName != nil {
+		if err := r.checkName(r); err != nil {
+			return err
+		}
+	}
+	return next.ServeTFTP(r)
+}
+
+// denied reports whether filename matches one of the deny patterns
+// or contains one of the deny_chars.
+func (u *UploadName) denied(r *Request, filename string) bool {
+	return (len(u.Deny) > 0 && matchPathFold(u.Deny, filename, r.foldCase)) ||
+		(u.DenyChars != "" && strings.ContainsAny(filename, u.DenyChars))
+}
+
+// slugify replaces every run of characters other than letters, digits,
+// dots, dashes and underscores in the elements of filename with a dash.
+func slugify(filename string) string {
+	elems := strings.Split(filename, "/")
+	for i, elem := range elems {
+		var b strings.Builder
+		dash := false
+		for _, c := range elem {
+			if unicode.IsLetter(c) || unicode.IsDigit(c) || strings.ContainsRune("._-", c) {
+				b.WriteRune(c)
+				dash = false
+			} else if !dash {
+				b.WriteByte('-')
+				dash = true
+			}
+		}
+		elems[i] = b.String()
+	}
+	return strings.Join(elems, "/")
+}
+
+// UnmarshalCaddyfile sets up the handler from Caddyfile tokens.
+//
+//	upload_name {
+//	    deny       <patterns...>
+//	    deny_chars <chars>
+//	    slugify
+//	    rename     <template>
+//	}
+func (u *UploadName) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume handler name
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "deny":
+			args := d.RemainingArgs()
+			if len(args) == 0 {
+				return d.ArgErr()
+			}
+			u.Deny = append(u.Deny, args...)
+		case "deny_chars":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			u.DenyChars = d.Val()
+		case "slugify":
+			u.Slugify = true
+		case "rename":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			u.Rename = d.Val()
+		default:
+			return d.Errf("unrecognized upload_name option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*UploadName)(nil)
+	_ MiddlewareHandler     = (*UploadName)(nil)
+	_ caddyfile.Unmarshaler = (*UploadName)(nil)
+)
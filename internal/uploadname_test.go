@@ -0,0 +1,72 @@
+package internal
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go.uber.org/zap"
+)
+
+func TestUploadNameSlugifiesRenamed(t *testing.T) {
+	u := &UploadName{Rename: "{tftp.client.ip}-{tftp.request.file}", Slugify: true, log: zap.NewNop()}
+	r := newWriteRequest(context.Background(), "test", "configs/my file.cfg", "", nil)
+	r.RemoteAddr.IP = net.ParseIP("2001:db8::1")
+	var got string
+	err := u.ServeTFTP(r, HandlerFunc(func(r *Request) error {
+		got = r.Filename
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "2001-db8-1-my-file.cfg"; got != want {
+		t.Errorf("stored as %q, want %q", got, want)
+	}
+}
+
+func TestUploadName(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		u        *UploadName
+		filename string
+		stored   string
+		code     int
+	}{
+		{name: "renamed", u: &UploadName{Rename: "{tftp.client.ip}-{tftp.request.file}", Slugify: true}, filename: "a b.txt", stored: "127.0.0.1-a-b.txt"},
+		{name: "denied", u: &UploadName{Deny: []string{"*.exe"}}, filename: "x.exe", code: errCodeAccessViolation},
+		{name: "renamed denied", u: &UploadName{Deny: []string{"*.exe"}, Rename: "{tftp.request.file}.exe"}, filename: "x", code: errCodeAccessViolation},
+		{name: "renamed deny_chars", u: &UploadName{DenyChars: ":", Rename: "{tftp.client.ip}:{tftp.request.file}"}, filename: "x", code: errCodeAccessViolation},
+		{name: "renamed empty", u: &UploadName{Rename: "{tftp.no_such_placeholder}"}, filename: "x", code: errCodeAccessViolation},
+		{name: "slugified empty", u: &UploadName{Slugify: true}, filename: "::", code: errCodeAccessViolation},
+		{name: "renamed hidden", u: &UploadName{Rename: "{tftp.request.file}.key"}, filename: "x", code: errCodeFileNotFound},
+		{name: "renamed into access rule", u: &UploadName{Rename: "secure/{tftp.request.file}"}, filename: "x", code: errCodeAccessViolation},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			root := t.TempDir()
+			srv := &Server{
+				Root:        root,
+				Hide:        []string{"*.key"},
+				AccessRules: []AccessRule{{Files: []string{"secure/*"}, Deny: []string{"127.0.0.0/8"}}},
+			}
+			addr := startServer(t, srv, tc.u, &FileServer{})
+			err := upload(t, addr, tc.filename, "data")
+			if tc.code != 0 {
+				wantCode(t, err, tc.code)
+				entries, _ := os.ReadDir(root)
+				if len(entries) != 0 {
+					t.Errorf("stored %d files", len(entries))
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, err := os.ReadFile(filepath.Join(root, tc.stored)); err != nil || string(data) != "data" {
+				t.Errorf("%s: %q, %v", tc.stored, data, err)
+			}
+		})
+	}
+}
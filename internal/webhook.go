@@ -0,0 +1,201 @@
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"go.uber.org/zap"
+)
+
+func init() {
+	caddy.RegisterModule(Webhook{})
+}
+
+// Webhook notifies an HTTP(S) endpoint of an upload with a POST request.
+//
+// By default, the request body is a JSON object describing the upload:
+//
+//	{
+//	    "filename":    "switch1.cfg",
+//	    "path":        "/srv/tftp/switch1.cfg",
+//	    "size":        1234,
+//	    "remote_addr": "192.0.2.10:50123"
+//	}
+//
+// If contents is enabled, the body is the uploaded file instead, and
+// the filename and client address are sent in the Tftp-Filename and
+// Tftp-Remote-Addr headers.
+type Webhook struct {
+	// The URL to POST to. Placeholders are supported; the values of the
+	// tftp.* placeholders are escaped like in the url of the
+	// http_upstream handler.
+	URL string `json:"url,omitempty"`
+
+	// Headers to add to the request.
+	// Placeholders are supported in the values.
+	Headers http.Header `json:"headers,omitempty"`
+
+	// Sends the contents of the uploaded file instead of its metadata.
+	Contents bool `json:"contents,omitempty"`
+
+	// The maximum time the request may take.
+	// Default is 30 seconds.
+	Timeout caddy.Duration `json:"timeout,omitempty"`
+
+	client *http.Client
+	log    *zap.Logger
+}
+
+// webhookPayload is the body of a webhook request without contents.
+type webhookPayload struct {
+	Filename   string `json:"filename"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	RemoteAddr string `json:"remote_addr"`
+}
+
+// CaddyModule returns the Caddy module information.
+func (Webhook) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "tftp.hooks.webhook",
+		New: func() caddy.Module { return new(Webhook) },
+	}
+}
+
+// Provision sets up the HTTP client.
+func (w *Webhook) Provision(ctx caddy.Context) error {
+	w.log = ctx.Logger()
+	if w.URL == "" {
+		return fmt.Errorf("url is required")
+	}
+	timeout := time.Duration(w.Timeout)
+	if timeout <= 0 {
+		timeout = 30 * time.Second
+	}
+	w.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// RunHook implements UploadHook.
+func (w *Webhook) RunHook(r *Request, path string) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	var body io.Reader
+	contentType := "application/json"
+	if w.Contents {
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		body = file
+		contentType = "application/octet-stream"
+	} else {
+		payload, err := json.Marshal(webhookPayload{
+			Filename:   r.Filename,
+			Path:       path,
+			Size:       info.Size(),
+			RemoteAddr: r.RemoteAddr.String(),
+		})
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(payload)
+	}
+
+	repl := r.Replacer()
+	u, err := replaceURL(repl, w.URL)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, u, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if w.Contents {
+		req.ContentLength = info.Size()
+		req.Header.Set("Tftp-Filename", r.Filename)
+		req.Header.Set("Tftp-Remote-Addr", r.RemoteAddr.String())
+	}
+	for name, values := range w.Headers {
+		for _, value := range values {
+			req.Header.Add(name, repl.ReplaceAll(value, ""))
+		}
+	}
+	w.log.Debug("posting to webhook", zap.String("transfer_id", r.ID), zap.String("url", u))
+	resp, err := w.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook responded with status %s", resp.Status)
+	}
+	return nil
+}
+
+// UnmarshalCaddyfile sets up the hook from Caddyfile tokens.
+//
+//	webhook <url> {
+//	    header   <name> <value>
+//	    contents
+//	    timeout  <duration>
+//	}
+func (w *Webhook) UnmarshalCaddyfile(d *caddyfile.Dispenser) error {
+	d.Next() // consume hook name
+	if !d.NextArg() {
+		return d.ArgErr()
+	}
+	w.URL = d.Val()
+	if d.NextArg() {
+		return d.ArgErr()
+	}
+	for d.NextBlock(0) {
+		switch d.Val() {
+		case "header":
+			var name, value string
+			if !d.Args(&name, &value) {
+				return d.ArgErr()
+			}
+			if w.Headers == nil {
+				w.Headers = make(http.Header)
+			}
+			w.Headers.Add(name, value)
+		case "contents":
+			w.Contents = true
+		case "timeout":
+			if !d.NextArg() {
+				return d.ArgErr()
+			}
+			dur, err := caddy.ParseDuration(d.Val())
+			if err != nil {
+				return d.Errf("parsing timeout duration: %v", err)
+			}
+			w.Timeout = caddy.Duration(dur)
+		default:
+			return d.Errf("unrecognized webhook option '%s'", d.Val())
+		}
+		if d.NextArg() {
+			return d.ArgErr()
+		}
+	}
+	return nil
+}
+
+// Interface guards
+var (
+	_ caddy.Provisioner     = (*Webhook)(nil)
+	_ UploadHook            = (*Webhook)(nil)
+	_ caddyfile.Unmarshaler = (*Webhook)(nil)
+)
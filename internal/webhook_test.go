@@ -0,0 +1,36 @@
+package internal
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/caddyserver/caddy/v2"
+)
+
+func TestWebhook(t *testing.T) {
+	var gotPath string
+	var got webhookPayload
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		gotPath = req.URL.EscapedPath()
+		_ = json.NewDecoder(req.Body).Decode(&got)
+	}))
+	defer srv.Close()
+
+	w := &Webhook{URL: srv.URL + "/uploads/{tftp.request.filename}"}
+	if err := w.Provision(caddy.Context{}); err != nil {
+		t.Fatal(err)
+	}
+	r := newWriteRequest(context.Background(), "test", "../cfg/sw 1.cfg", "", nil)
+	if err := w.RunHook(r, "webhook_test.go"); err != nil {
+		t.Fatal(err)
+	}
+	if want := "/uploads/cfg/sw%201.cfg"; gotPath != want {
+		t.Errorf("posted to %q, want %q", gotPath, want)
+	}
+	if got.Filename != "../cfg/sw 1.cfg" || got.Path != "webhook_test.go" || got.Size == 0 {
+		t.Errorf("payload = %+v", got)
+	}
+}